@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	BackendRedis  = "redis"
+
+	// interval for checking if another instance has refreshed a locked key
+	lockPollInterval = 1 * time.Second
+
+	lockKeyPrefix = "lock:"
+)
+
+type (
+	// Backend is a key/value store for serialized cache entries (metrics and service discovery)
+	Backend interface {
+		// Get returns the cached value of key, ok is false if the key doesn't exist or is expired
+		Get(key string) (value []byte, ok bool)
+
+		// Set stores value for key and expires it after ttl
+		Set(key string, value []byte, ttl time.Duration) error
+	}
+
+	// Locker is implemented by backends shared between multiple exporter instances,
+	// so only one instance refreshes a key
+	Locker interface {
+		// TryLock acquires the refresh lock of key (expires after ttl), ok is false if the lock is held by another instance
+		TryLock(key string, ttl time.Duration) (unlock func(), ok bool, err error)
+	}
+)
+
+// New creates the cache backend configured by opts, name is used to separate caches inside the same backend
+// (eg. subdirectory for file backend or key namespace for redis backend)
+func New(opts config.Opts, name string, logger *slog.Logger) (Backend, error) {
+	switch opts.Prober.CacheBackend {
+	case "", BackendMemory:
+		return NewMemoryBackend(), nil
+	case BackendFile:
+		if opts.Prober.CachePath == "" {
+			return nil, fmt.Errorf(`cache backend "%v" requires a cache path`, opts.Prober.CacheBackend)
+		}
+		return NewFileBackend(filepath.Join(opts.Prober.CachePath, name), logger)
+	case BackendRedis:
+		if opts.Prober.CacheRedis.Url == "" {
+			return nil, fmt.Errorf(`cache backend "%v" requires a redis url`, opts.Prober.CacheBackend)
+		}
+		return NewRedisBackendFromUrl(opts.Prober.CacheRedis.Url, opts.Prober.CacheRedis.Prefix+name+":", logger)
+	default:
+		return nil, fmt.Errorf(`cache backend "%v" is not supported`, opts.Prober.CacheBackend)
+	}
+}
+
+// TryLock acquires the refresh lock of key if the backend is shared (implements Locker),
+// for local backends it always succeeds
+func TryLock(backend Backend, key string, ttl time.Duration) (unlock func(), ok bool) {
+	locker, isLocker := backend.(Locker)
+	if !isLocker {
+		return func() {}, true
+	}
+
+	unlock, ok, err := locker.TryLock(key, ttl)
+	if err != nil {
+		// refresh without lock if lock is not available
+		return func() {}, true
+	}
+	return unlock, ok
+}
+
+// LockOrWait acquires the refresh lock of key, if another instance holds the lock it waits until
+// the key is refreshed by the other instance and returns the cached value (unlock is nil in this case).
+// If the lock is released without refreshed value (or ctx is done) the caller has to refresh the key.
+func LockOrWait(ctx context.Context, backend Backend, key string, ttl time.Duration) (value []byte, unlock func()) {
+	for {
+		unlock, ok := TryLock(backend, key, ttl)
+		if ok {
+			return nil, unlock
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, func() {}
+		case <-time.After(lockPollInterval):
+		}
+
+		if value, exists := backend.Get(key); exists {
+			return value, nil
+		}
+	}
+}
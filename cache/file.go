@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+const (
+	fileBackendSuffix = ".json"
+)
+
+type (
+	// FileBackend keeps cache entries in memory and persists them as files (one file per key),
+	// unexpired entries are restored on startup
+	FileBackend struct {
+		path   string
+		cache  *gocache.Cache
+		logger *slog.Logger
+	}
+
+	fileBackendEntry struct {
+		Key       string    `json:"key"`
+		ExpiresAt time.Time `json:"expiresAt"`
+		Value     []byte    `json:"value"`
+	}
+)
+
+func NewFileBackend(path string, logger *slog.Logger) (*FileBackend, error) {
+	if err := os.MkdirAll(path, 0o750); err != nil {
+		return nil, fmt.Errorf(`unable to create cache directory "%v": %w`, path, err)
+	}
+
+	b := &FileBackend{
+		path:   path,
+		cache:  gocache.New(1*time.Minute, 1*time.Minute),
+		logger: logger.With(slog.String("cachePath", path)),
+	}
+
+	// remove persisted entry if expired entry is removed from memory
+	b.cache.OnEvicted(func(key string, _ interface{}) {
+		if _, exists := b.cache.Get(key); exists {
+			// already replaced by a new entry
+			return
+		}
+
+		if err := os.Remove(b.filename(key)); err != nil && !os.IsNotExist(err) {
+			b.logger.Warn("unable to remove cache file", slog.String("key", key), slog.Any("error", err.Error()))
+		}
+	})
+
+	if err := b.restore(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *FileBackend) Get(key string) ([]byte, bool) {
+	if v, ok := b.cache.Get(key); ok {
+		if value, ok := v.([]byte); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func (b *FileBackend) Set(key string, value []byte, ttl time.Duration) error {
+	b.cache.Set(key, value, ttl)
+
+	entry := fileBackendEntry{
+		Key:       key,
+		ExpiresAt: time.Now().Add(ttl),
+		Value:     value,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// write to temp file first, so restore never reads partial files
+	tmpFile, err := os.CreateTemp(b.path, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// cleanup if rename failed
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), b.filename(key))
+}
+
+// restore loads unexpired entries from cache directory and removes expired ones
+func (b *FileBackend) restore() error {
+	files, err := os.ReadDir(b.path)
+	if err != nil {
+		return fmt.Errorf(`unable to read cache directory "%v": %w`, b.path, err)
+	}
+
+	restored := 0
+	for _, file := range files {
+		filename := filepath.Join(b.path, file.Name())
+
+		if file.IsDir() {
+			continue
+		}
+
+		// leftover of interrupted write
+		if strings.HasPrefix(file.Name(), ".tmp-") {
+			_ = os.Remove(filename)
+			continue
+		}
+
+		if !strings.HasSuffix(file.Name(), fileBackendSuffix) {
+			continue
+		}
+
+		var entry fileBackendEntry
+		/* #nosec G304 */
+		data, err := os.ReadFile(filename)
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		if err != nil {
+			b.logger.Warn("unable to read cache file, removing it", slog.String("file", filename), slog.Any("error", err.Error()))
+			_ = os.Remove(filename)
+			continue
+		}
+
+		ttl := time.Until(entry.ExpiresAt)
+		if ttl <= 0 {
+			_ = os.Remove(filename)
+			continue
+		}
+
+		b.cache.Set(entry.Key, entry.Value, ttl)
+		restored++
+	}
+
+	b.logger.Info(fmt.Sprintf("restored %v cache entries", restored))
+	return nil
+}
+
+func (b *FileBackend) filename(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(b.path, hex.EncodeToString(hash[:])+fileBackendSuffix)
+}
@@ -0,0 +1,91 @@
+package cache_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webdevops/azure-metrics-exporter/cache"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestFileBackendRestore(t *testing.T) {
+	path := t.TempDir()
+
+	backend, err := cache.NewFileBackend(path, newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.Set("valid", []byte(`{"value":1}`), 1*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// cache file of the valid entry (only file in cache directory)
+	validFiles := cacheFileNames(t, path)
+	if len(validFiles) != 1 {
+		t.Fatalf("expected one cache file, got %v", validFiles)
+	}
+
+	if err := backend.Set("expired", []byte(`{"value":2}`), 1*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// leftover of interrupted write and corrupt cache file
+	if err := os.WriteFile(filepath.Join(path, ".tmp-123"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "corrupt.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait until the entry is expired (no fixed sleep, slow test environments)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, ok := backend.Get("expired"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`key "expired" did not expire`)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	restored, err := cache.NewFileBackend(path, newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := restored.Get("valid"); !ok || !bytes.Equal(value, []byte(`{"value":1}`)) {
+		t.Errorf(`expected restored value for key "valid", got %q (found: %v)`, value, ok)
+	}
+
+	if _, ok := restored.Get("expired"); ok {
+		t.Errorf(`expected key "expired" not to be restored`)
+	}
+
+	if files := cacheFileNames(t, path); len(files) != 1 || files[0] != validFiles[0] {
+		t.Errorf("expected only the cache file of the valid entry to be kept, got %v", files)
+	}
+}
+
+// cacheFileNames returns the names of all files in the cache directory
+func cacheFileNames(t *testing.T, path string) (names []string) {
+	t.Helper()
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
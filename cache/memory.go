@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+type (
+	// MemoryBackend keeps cache entries in memory (lost on restart)
+	MemoryBackend struct {
+		cache *gocache.Cache
+	}
+)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		cache: gocache.New(1*time.Minute, 1*time.Minute),
+	}
+}
+
+func (b *MemoryBackend) Get(key string) ([]byte, bool) {
+	if v, ok := b.cache.Get(key); ok {
+		if value, ok := v.([]byte); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func (b *MemoryBackend) Set(key string, value []byte, ttl time.Duration) error {
+	b.cache.Set(key, value, ttl)
+	return nil
+}
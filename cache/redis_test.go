@@ -0,0 +1,276 @@
+package cache_test
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/webdevops/azure-metrics-exporter/cache"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+// newTestRedisBackend creates a redis backend (one exporter replica) connected to the in-process redis server
+func newTestRedisBackend(t *testing.T, server *miniredis.Miniredis, prefix string) *cache.RedisBackend {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return cache.NewRedisBackend(client, prefix, newTestLogger())
+}
+
+func TestRedisBackendSetGet(t *testing.T) {
+	server := miniredis.RunT(t)
+	backend := newTestRedisBackend(t, server, "azure-metrics-exporter:metrics:")
+
+	if _, ok := backend.Get("missing"); ok {
+		t.Error(`expected key "missing" not to exist`)
+	}
+
+	if err := backend.Set("key", []byte("value"), 1*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := backend.Get("key"); !ok || string(value) != "value" {
+		t.Errorf(`expected "value", got %q (found: %v)`, value, ok)
+	}
+
+	if ttl := server.TTL("azure-metrics-exporter:metrics:key"); ttl != 1*time.Minute {
+		t.Errorf("expected ttl of 1m, got %v", ttl)
+	}
+
+	server.FastForward(1 * time.Minute)
+	if _, ok := backend.Get("key"); ok {
+		t.Error(`expected key "key" to be expired`)
+	}
+}
+
+func TestRedisBackendNamespace(t *testing.T) {
+	server := miniredis.RunT(t)
+	metricsBackend := newTestRedisBackend(t, server, "azure-metrics-exporter:metrics:")
+	servicediscoveryBackend := newTestRedisBackend(t, server, "azure-metrics-exporter:servicediscovery:")
+
+	if err := metricsBackend.Set("key", []byte("metrics"), 1*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := servicediscoveryBackend.Set("key", []byte("servicediscovery"), 1*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := metricsBackend.Get("key"); !ok || string(value) != "metrics" {
+		t.Errorf(`expected "metrics", got %q (found: %v)`, value, ok)
+	}
+	if value, ok := servicediscoveryBackend.Get("key"); !ok || string(value) != "servicediscovery" {
+		t.Errorf(`expected "servicediscovery", got %q (found: %v)`, value, ok)
+	}
+
+	keys := server.Keys()
+	expectedKeys := []string{"azure-metrics-exporter:metrics:key", "azure-metrics-exporter:servicediscovery:key"}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("expected keys %v, got %v", expectedKeys, keys)
+	}
+}
+
+func TestRedisBackendMetricListRoundTrip(t *testing.T) {
+	server := miniredis.RunT(t)
+	backend := newTestRedisBackend(t, server, "metrics:")
+
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metricList := metrics.NewMetricList()
+	metricList.SetMetricHelp("azurerm_test", "test metric")
+	metricList.Add(
+		"azurerm_test",
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a", "unit": "Percent"}, Value: 42, Timestamp: &timestamp},
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "b", "unit": "Percent"}, Value: math.Inf(1), Counter: true},
+	)
+
+	cacheData, err := json.Marshal(metrics.MetricsCacheEntry{MetricList: metricList, CreatedAt: timestamp, ExpiresAt: timestamp.Add(1 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Set("probe", cacheData, 1*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	cachedData, ok := backend.Get("probe")
+	if !ok {
+		t.Fatal(`expected key "probe" to exist`)
+	}
+
+	entry := metrics.MetricsCacheEntry{}
+	if err := json.Unmarshal(cachedData, &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if !entry.CreatedAt.Equal(timestamp) || !entry.ExpiresAt.Equal(timestamp.Add(1*time.Minute)) {
+		t.Errorf("unexpected cache entry times: %v, %v", entry.CreatedAt, entry.ExpiresAt)
+	}
+	if !reflect.DeepEqual(entry.MetricList, metricList) {
+		t.Errorf("expected metric list %+v, got %+v", metricList, entry.MetricList)
+	}
+}
+
+func TestRedisBackendResourceListRoundTrip(t *testing.T) {
+	server := miniredis.RunT(t)
+	backend := newTestRedisBackend(t, server, "servicediscovery:")
+
+	resourceList := []metrics.AzureResource{
+		{
+			ID:       "/subscriptions/xxx/resourceGroups/rg/providers/Microsoft.Cache/Redis/redis-a",
+			Location: "westeurope",
+			Tags:     map[string]string{"owner": "team-a"},
+		},
+		{
+			ID:       "/subscriptions/xxx/resourceGroups/rg/providers/Microsoft.Cache/Redis/redis-b",
+			Location: "northeurope",
+			Tags:     map[string]string{},
+		},
+	}
+
+	cacheData, err := json.Marshal(resourceList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Set("resources", cacheData, 1*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	cachedData, ok := backend.Get("resources")
+	if !ok {
+		t.Fatal(`expected key "resources" to exist`)
+	}
+
+	var cachedResourceList []metrics.AzureResource
+	if err := json.Unmarshal(cachedData, &cachedResourceList); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cachedResourceList, resourceList) {
+		t.Errorf("expected resource list %+v, got %+v", resourceList, cachedResourceList)
+	}
+}
+
+func TestRedisBackendTryLock(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicaA := newTestRedisBackend(t, server, "metrics:")
+	replicaB := newTestRedisBackend(t, server, "metrics:")
+
+	unlockA, ok := cache.TryLock(replicaA, "probe", 1*time.Minute)
+	if !ok {
+		t.Fatal("expected replica A to acquire the lock")
+	}
+
+	if _, ok := cache.TryLock(replicaB, "probe", 1*time.Minute); ok {
+		t.Fatal("expected replica B not to acquire the lock held by replica A")
+	}
+
+	// locks of other keys are independent
+	unlockOther, ok := cache.TryLock(replicaB, "other", 1*time.Minute)
+	if !ok {
+		t.Fatal(`expected replica B to acquire the lock of key "other"`)
+	}
+	unlockOther()
+
+	unlockA()
+
+	unlockB, ok := cache.TryLock(replicaB, "probe", 1*time.Minute)
+	if !ok {
+		t.Fatal("expected replica B to acquire the lock released by replica A")
+	}
+	unlockB()
+}
+
+func TestRedisBackendTryLockExpired(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicaA := newTestRedisBackend(t, server, "metrics:")
+	replicaB := newTestRedisBackend(t, server, "metrics:")
+
+	unlockA, ok := cache.TryLock(replicaA, "probe", 10*time.Second)
+	if !ok {
+		t.Fatal("expected replica A to acquire the lock")
+	}
+
+	server.FastForward(10 * time.Second)
+
+	unlockB, ok := cache.TryLock(replicaB, "probe", 10*time.Second)
+	if !ok {
+		t.Fatal("expected replica B to acquire the expired lock")
+	}
+
+	// releasing the expired lock must not release the lock of replica B
+	unlockA()
+	if _, ok := cache.TryLock(replicaA, "probe", 10*time.Second); ok {
+		t.Fatal("expected lock of replica B to be kept")
+	}
+
+	unlockB()
+}
+
+func TestRedisBackendLockOrWait(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicaA := newTestRedisBackend(t, server, "metrics:")
+	replicaB := newTestRedisBackend(t, server, "metrics:")
+
+	unlockA, ok := cache.TryLock(replicaA, "probe", 1*time.Minute)
+	if !ok {
+		t.Fatal("expected replica A to acquire the lock")
+	}
+
+	// replica A refreshes the key while replica B is waiting
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := replicaA.Set("probe", []byte("refreshed"), 1*time.Minute); err != nil {
+			t.Error(err)
+		}
+		unlockA()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	value, unlockB := cache.LockOrWait(ctx, replicaB, "probe", 1*time.Minute)
+	if unlockB != nil {
+		unlockB()
+		t.Fatal("expected replica B to use the value written by replica A instead of acquiring the lock")
+	}
+	if string(value) != "refreshed" {
+		t.Errorf(`expected "refreshed", got %q`, value)
+	}
+}
+
+func TestRedisBackendLockOrWaitReleasedWithoutValue(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicaA := newTestRedisBackend(t, server, "metrics:")
+	replicaB := newTestRedisBackend(t, server, "metrics:")
+
+	unlockA, ok := cache.TryLock(replicaA, "probe", 1*time.Minute)
+	if !ok {
+		t.Fatal("expected replica A to acquire the lock")
+	}
+
+	// replica A fails to refresh the key
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlockA()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	value, unlockB := cache.LockOrWait(ctx, replicaB, "probe", 1*time.Minute)
+	if unlockB == nil {
+		t.Fatalf("expected replica B to acquire the lock, got value %q", value)
+	}
+	if value != nil {
+		t.Errorf("expected no value, got %q", value)
+	}
+	unlockB()
+}
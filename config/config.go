@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	Config struct {
+		Jobs map[string]*ConfigJob `yaml:"jobs"`
+
+		// named Kusto queries for /probe/metrics/resourcegraph (parameter queryName)
+		Queries map[string]string `yaml:"queries"`
+	}
+
+	// ConfigJob defines a named probe job, all fields are named after their query parameter counterpart
+	ConfigJob struct {
+		Endpoint string `yaml:"endpoint"`
+
+		// background collection interval (disabled if not set)
+		Schedule time.Duration `yaml:"schedule"`
+
+		Name            string   `yaml:"name"`
+		Subscriptions   []string `yaml:"subscription"`
+		Targets         []string `yaml:"target"`
+		Regions         []string `yaml:"region"`
+		ResourceGroups  []string `yaml:"resourceGroup"`
+		ResourceType    string   `yaml:"resourceType"`
+		Filter          string   `yaml:"filter"`
+		Timespan        string   `yaml:"timespan"`
+		Interval        string   `yaml:"interval"`
+		Metrics         []string `yaml:"metric"`
+		MetricExclude   []string `yaml:"metricExclude"`
+		MetricNamespace string   `yaml:"metricNamespace"`
+		Aggregations    []string `yaml:"aggregation"`
+
+		MetricFilter       string `yaml:"metricFilter"`
+		MetricTop          *int32 `yaml:"metricTop"`
+		MetricOrderBy      string `yaml:"metricOrderBy"`
+		ValidateDimensions *bool  `yaml:"validateDimensions"`
+
+		ManagementGroups        []string `yaml:"managementGroup"`
+		SubscriptionTagSelector string   `yaml:"subscriptionTagSelector"`
+		SubscriptionStates      []string `yaml:"subscriptionState"`
+		SubscriptionNameFilter  string   `yaml:"subscriptionNameFilter"`
+		SubscriptionTagLabels   []string `yaml:"subscriptionTagLabel"`
+
+		Datapoint      string `yaml:"datapoint"`
+		Batch          *bool  `yaml:"batch"`
+		UnitLabel      *bool  `yaml:"unitLabel"`
+		NormalizeUnits *bool  `yaml:"normalizeUnits"`
+		Cumulative     *bool  `yaml:"cumulative"`
+
+		Labels    []string `yaml:"labels"`
+		Query     string   `yaml:"query"`
+		QueryName string   `yaml:"queryName"`
+
+		ExcludeResourceId    []string `yaml:"excludeResourceId"`
+		ExcludeTag           []string `yaml:"excludeTag"`
+		ExcludeLocation      []string `yaml:"excludeLocation"`
+		ExcludeResourceGroup []string `yaml:"excludeResourceGroup"`
+
+		MetricTagName      string `yaml:"metricTagName"`
+		AggregationTagName string `yaml:"aggregationTagName"`
+
+		Template string `yaml:"template"`
+		Help     string `yaml:"help"`
+		Cache    string `yaml:"cache"`
+
+		// additional query parameters
+		Params map[string][]string `yaml:"params"`
+	}
+)
+
+func NewConfigFromFile(path string) (config Config, err error) {
+	/* #nosec G304 */
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf(`unable to read config file "%v": %w`, path, err)
+	}
+
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf(`unable to parse config file "%v": %w`, path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) Validate() error {
+	for name, job := range c.Jobs {
+		if job == nil {
+			return fmt.Errorf(`job "%v" is empty`, name)
+		}
+
+		switch job.Endpoint {
+		case ProbeMetricsResourceUrl, ProbeMetricsListUrl, ProbeMetricsSubscriptionUrl, ProbeMetricsScrapeUrl, ProbeMetricsResourceGraphUrl:
+		case "":
+			return fmt.Errorf(`job "%v" has no endpoint`, name)
+		default:
+			return fmt.Errorf(`job "%v" has unsupported endpoint "%v"`, name, job.Endpoint)
+		}
+
+		if job.Schedule < 0 {
+			return fmt.Errorf(`job "%v" has invalid schedule "%v"`, name, job.Schedule)
+		}
+
+		switch job.Datapoint {
+		case "", "last", "timestamp":
+		default:
+			return fmt.Errorf(`job "%v" has invalid datapoint "%v"`, name, job.Datapoint)
+		}
+
+		if job.Query != "" && job.QueryName != "" {
+			return fmt.Errorf(`job "%v": "query" and "queryName" are mutually exclusive`, name)
+		}
+
+		if job.QueryName != "" {
+			if _, err := c.GetQuery(job.QueryName); err != nil {
+				return fmt.Errorf(`job "%v": %w`, name, err)
+			}
+		}
+	}
+
+	for name, query := range c.Queries {
+		if strings.TrimSpace(query) == "" {
+			return fmt.Errorf(`query "%v" is empty`, name)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) GetJob(name string) (*ConfigJob, error) {
+	if job, exists := c.Jobs[name]; exists {
+		return job, nil
+	}
+
+	return nil, fmt.Errorf(`job "%v" not found`, name)
+}
+
+func (c *Config) GetQuery(name string) (string, error) {
+	if query, exists := c.Queries[name]; exists {
+		return query, nil
+	}
+
+	return "", fmt.Errorf(`query "%v" not found`, name)
+}
+
+// Values returns the job settings as query parameters (as they would be passed by Prometheus)
+func (j *ConfigJob) Values() url.Values {
+	params := url.Values{}
+
+	for name, values := range j.Params {
+		params[name] = values
+	}
+
+	setValue := func(name, value string) {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	setList := func(name string, values []string) {
+		if len(values) >= 1 {
+			params.Set(name, strings.Join(values, ","))
+		}
+	}
+
+	setBool := func(name string, value *bool) {
+		if value != nil {
+			params.Set(name, strconv.FormatBool(*value))
+		}
+	}
+
+	setValue("name", j.Name)
+	setList("subscription", j.Subscriptions)
+	setList("target", j.Targets)
+	setList("region", j.Regions)
+	setList("resourceGroup", j.ResourceGroups)
+	setList("managementGroup", j.ManagementGroups)
+	setValue("subscriptionTagSelector", j.SubscriptionTagSelector)
+	setList("subscriptionState", j.SubscriptionStates)
+	setValue("subscriptionNameFilter", j.SubscriptionNameFilter)
+	setList("subscriptionTagLabel", j.SubscriptionTagLabels)
+	setValue("resourceType", j.ResourceType)
+	setValue("filter", j.Filter)
+	setValue("timespan", j.Timespan)
+	setValue("interval", j.Interval)
+	setList("metric", j.Metrics)
+	setList("metricExclude", j.MetricExclude)
+	setValue("metricNamespace", j.MetricNamespace)
+	setList("aggregation", j.Aggregations)
+	setValue("metricFilter", j.MetricFilter)
+	setValue("metricOrderBy", j.MetricOrderBy)
+	setValue("datapoint", j.Datapoint)
+	setList("labels", j.Labels)
+	setValue("query", j.Query)
+	setValue("queryName", j.QueryName)
+	setList("excludeTag", j.ExcludeTag)
+	setList("excludeLocation", j.ExcludeLocation)
+	setList("excludeResourceGroup", j.ExcludeResourceGroup)
+	setValue("metricTagName", j.MetricTagName)
+	setValue("aggregationTagName", j.AggregationTagName)
+	setValue("template", j.Template)
+	setValue("help", j.Help)
+	setValue("cache", j.Cache)
+
+	// regexps may contain commas, passed as multiple values
+	if len(j.ExcludeResourceId) >= 1 {
+		params["excludeResourceId"] = j.ExcludeResourceId
+	}
+
+	if j.MetricTop != nil {
+		params.Set("metricTop", strconv.FormatInt(int64(*j.MetricTop), 10))
+	}
+
+	setBool("batch", j.Batch)
+	setBool("validateDimensions", j.ValidateDimensions)
+	setBool("unitLabel", j.UnitLabel)
+	setBool("normalizeUnits", j.NormalizeUnits)
+	setBool("cumulative", j.Cumulative)
+
+	return params
+}
+
+// IsScheduled returns true if the job is collected in the background
+func (j *ConfigJob) IsScheduled() bool {
+	return j.Schedule > 0
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigJobValues(t *testing.T) {
+	enabled := true
+	job := &ConfigJob{
+		Endpoint:                ProbeMetricsListUrl,
+		Subscriptions:           []string{"*"},
+		ResourceGroups:          []string{"rg-a", "rg-b*"},
+		ManagementGroups:        []string{"mg"},
+		SubscriptionTagSelector: "env=prod,team in (a,b)",
+		SubscriptionStates:      []string{"Enabled", "Warned"},
+		SubscriptionNameFilter:  "^prod-(a|b),x$",
+		NormalizeUnits:          &enabled,
+		Cumulative:              &enabled,
+		Datapoint:               "last",
+		ExcludeResourceId:       []string{"/vm-(1,2)$"},
+	}
+
+	expected := map[string][]string{
+		"subscription":            {"*"},
+		"resourceGroup":           {"rg-a,rg-b*"},
+		"managementGroup":         {"mg"},
+		"subscriptionTagSelector": {"env=prod,team in (a,b)"},
+		"subscriptionState":       {"Enabled,Warned"},
+		"subscriptionNameFilter":  {"^prod-(a|b),x$"},
+		"normalizeUnits":          {"true"},
+		"cumulative":              {"true"},
+		"datapoint":               {"last"},
+		"excludeResourceId":       {"/vm-(1,2)$"},
+	}
+
+	values := job.Values()
+	if len(values) != len(expected) {
+		t.Errorf("expected %v parameters, got %v: %v", len(expected), len(values), values)
+	}
+
+	for name, expectedValue := range expected {
+		if strings.Join(values[name], "|") != strings.Join(expectedValue, "|") {
+			t.Errorf("parameter %q: expected %v, got %v", name, expectedValue, values[name])
+		}
+	}
+
+	if _, exists := values["unitLabel"]; exists {
+		t.Errorf("unset parameter unitLabel should not be passed")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		job   ConfigJob
+		error string
+	}{
+		{"valid", ConfigJob{Endpoint: ProbeMetricsListUrl}, ""},
+		{"no endpoint", ConfigJob{}, "has no endpoint"},
+		{"unsupported endpoint", ConfigJob{Endpoint: "/probe/foo"}, "unsupported endpoint"},
+		{"invalid datapoint", ConfigJob{Endpoint: ProbeMetricsListUrl, Datapoint: "first"}, "invalid datapoint"},
+		{"query and queryName", ConfigJob{Endpoint: ProbeMetricsResourceGraphUrl, Query: "Resources", QueryName: "test"}, "mutually exclusive"},
+		{"unknown queryName", ConfigJob{Endpoint: ProbeMetricsResourceGraphUrl, QueryName: "unknown"}, "not found"},
+		{"queryName", ConfigJob{Endpoint: ProbeMetricsResourceGraphUrl, QueryName: "test"}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			job := tc.job
+			config := Config{
+				Jobs:    map[string]*ConfigJob{"test": &job},
+				Queries: map[string]string{"test": "Resources"},
+			}
+
+			err := config.Validate()
+			switch {
+			case tc.error == "" && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case tc.error != "" && err == nil:
+				t.Errorf("expected error %q, got none", tc.error)
+			case tc.error != "" && !strings.Contains(err.Error(), tc.error):
+				t.Errorf("expected error %q, got %v", tc.error, err)
+			}
+		})
+	}
+}
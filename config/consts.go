@@ -17,4 +17,12 @@ const (
 
 	ProbeMetricsResourceGraphUrl            = "/probe/metrics/resourcegraph"
 	ProbeMetricsResourceGraphTimeoutDefault = 120
+
+	ProbeMetricsDefinitionsUrl            = "/probe/metrics/definitions"
+	ProbeMetricsDefinitionsTimeoutDefault = 120
+
+	ProbeJobUrl = "/probe/job"
+
+	ServiceDiscoveryHttpUrl            = "/sd/http"
+	ServiceDiscoveryHttpTimeoutDefault = 120
 )
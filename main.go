@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/base64"
 	"errors"
@@ -9,19 +10,23 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
-	"time"
+	"syscall"
 
 	"github.com/google/uuid"
 	"github.com/jessevdk/go-flags"
-	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/azuresdk/azidentity"
 	"github.com/webdevops/go-common/azuresdk/prometheus/tracing"
 
+	"github.com/webdevops/azure-metrics-exporter/cache"
 	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+	"github.com/webdevops/azure-metrics-exporter/otlp"
+	"github.com/webdevops/azure-metrics-exporter/remotewrite"
 )
 
 const (
@@ -33,6 +38,7 @@ const (
 var (
 	argparser *flags.Parser
 	Opts      config.Opts
+	Config    config.Config
 
 	AzureClient             *armclient.ArmClient
 	AzureResourceTagManager *armclient.ResourceTagManager
@@ -40,8 +46,23 @@ var (
 	prometheusCollectTime    *prometheus.SummaryVec
 	prometheusMetricRequests *prometheus.CounterVec
 
-	metricsCache *cache.Cache
-	azureCache   *cache.Cache
+	prometheusMetricsCacheRequests *prometheus.CounterVec
+	prometheusMetricsCacheAge      *prometheus.SummaryVec
+	prometheusMetricsCacheRefresh  *prometheus.CounterVec
+
+	prometheusServiceDiscoveryExcluded *prometheus.CounterVec
+
+	metricsCache cache.Backend
+	azureCache   cache.Backend
+
+	// deduplication of concurrent identical probes
+	probeCoalescer *metrics.ProbeCoalescer
+
+	// push of collected metrics (nil if remote-write is disabled)
+	remoteWriter *remotewrite.Writer
+
+	// export of collected metrics via OTLP (nil if disabled)
+	otlpExporter *otlp.Exporter
 
 	//go:embed templates/*.html
 	templates embed.FS
@@ -59,15 +80,26 @@ func main() {
 	logger.Info(fmt.Sprintf("starting azure-metrics-exporter v%s (%s; %s; by %v at %v)", gitTag, gitCommit, runtime.Version(), Author, buildDate))
 	logger.Info(string(Opts.GetJson()))
 	initSystem()
-	metricsCache = cache.New(1*time.Minute, 1*time.Minute)
-	azureCache = cache.New(1*time.Minute, 1*time.Minute)
+	initConfig()
+	initCache()
+	probeCoalescer = metrics.NewProbeCoalescer()
 
 	logger.Info("init Azure connection")
 	initAzureConnection()
 	initMetricCollector()
+	initRemoteWrite()
+	initOtlp()
+
+	// cancelled on shutdown (SIGINT/SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	initScheduler(ctx)
 
 	logger.Info("starting http server", slog.String("bind", Opts.Server.Bind))
-	startHttpServer()
+	startHttpServer(ctx)
+
+	shutdownRemoteWrite()
 }
 
 func initArgparser() {
@@ -87,6 +119,81 @@ func initArgparser() {
 	}
 }
 
+func initConfig() {
+	if Opts.Config.Path == "" {
+		return
+	}
+
+	logger.Info("reading config file", slog.String("path", Opts.Config.Path))
+	var err error
+	Config, err = config.NewConfigFromFile(Opts.Config.Path)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	logger.Info(fmt.Sprintf("found %v jobs in config file", len(Config.Jobs)))
+}
+
+func initCache() {
+	var err error
+
+	logger.Info("init cache", slog.String("backend", Opts.Prober.CacheBackend))
+	metricsCache, err = cache.New(Opts, "metrics", logger.Logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	azureCache, err = cache.New(Opts, "servicediscovery", logger.Logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+}
+
+func initRemoteWrite() {
+	var err error
+
+	remoteWriter, err = remotewrite.New(Opts, UserAgent+gitTag, logger.Logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	if remoteWriter != nil {
+		logger.Info("enabled remote-write", slog.Int("endpoints", len(Opts.RemoteWrite.Url)))
+	}
+}
+
+// shutdownRemoteWrite sends the queued remote-write requests before exit (limited by remote-write timeout)
+func shutdownRemoteWrite() {
+	if remoteWriter == nil {
+		return
+	}
+
+	logger.Info("sending queued remote-write requests")
+	ctx, cancel := context.WithTimeout(context.Background(), Opts.RemoteWrite.Timeout)
+	defer cancel()
+	remoteWriter.Shutdown(ctx)
+}
+
+func initOtlp() {
+	var err error
+
+	otlpExporter, err = otlp.New(Opts, UserAgent+gitTag, gitTag, logger.Logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	if otlpExporter != nil {
+		logger.Info("enabled OTLP export", slog.String("protocol", Opts.OTLP.Protocol), slog.Bool("probes", Opts.OTLP.Probes))
+	}
+}
+
+// registerMetricListCollectedCallbacks pushes metrics collected by probes to remote-write and OTLP (only with --otlp.probes)
+func registerMetricListCollectedCallbacks(prober *metrics.MetricProber) {
+	prober.RegisterMetricListCollectedCallback(remoteWriter.Push)
+	if Opts.OTLP.Probes {
+		prober.RegisterMetricListCollectedCallback(otlpExporter.Export)
+	}
+}
+
 func initAzureConnection() {
 	var err error
 
@@ -118,7 +225,7 @@ func initAzureConnection() {
 }
 
 // start and handle prometheus handler
-func startHttpServer() {
+func startHttpServer(ctx context.Context) {
 	mux := http.NewServeMux()
 
 	// healthz
@@ -147,6 +254,12 @@ func startHttpServer() {
 
 	mux.HandleFunc(config.ProbeMetricsResourceGraphUrl, probeMetricsResourceGraphHandler)
 
+	mux.HandleFunc(config.ProbeMetricsDefinitionsUrl, probeMetricsDefinitionsHandler)
+
+	mux.HandleFunc(config.ServiceDiscoveryHttpUrl, serviceDiscoveryHttpHandler)
+
+	mux.HandleFunc(config.ProbeJobUrl, probeJobHandler)
+
 	// report
 	tmpl := template.Must(template.ParseFS(templates, "templates/*.html"))
 	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
@@ -181,7 +294,19 @@ func startHttpServer() {
 		ReadTimeout:  Opts.Server.ReadTimeout,
 		WriteTimeout: Opts.Server.WriteTimeout,
 	}
-	if err := srv.ListenAndServe(); err != nil {
+
+	go func() {
+		<-ctx.Done()
+		logger.Info("stopping http server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), Opts.Server.WriteTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Fatal(err.Error())
 	}
 }
@@ -203,7 +328,7 @@ func initMetricCollector() {
 	prometheusMetricRequests = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "azurerm_stats_metric_requests",
-			Help: "Azure Insights resource requests",
+			Help: "Azure Insights resource requests (per probe target)",
 		},
 		[]string{
 			"subscriptionID",
@@ -213,4 +338,51 @@ func initMetricCollector() {
 		},
 	)
 	prometheus.MustRegister(prometheusMetricRequests)
+
+	prometheusMetricsCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_metric_cache_requests",
+			Help: "Azure Insights metrics cache requests (hit, miss, stale)",
+		},
+		[]string{
+			"handler",
+			"result",
+		},
+	)
+	prometheus.MustRegister(prometheusMetricsCacheRequests)
+
+	prometheusMetricsCacheAge = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name: "azurerm_stats_metric_cache_age_seconds",
+			Help: "Azure Insights metrics cache age (seconds) of served cache entries",
+		},
+		[]string{
+			"handler",
+		},
+	)
+	prometheus.MustRegister(prometheusMetricsCacheAge)
+
+	prometheusMetricsCacheRefresh = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_metric_cache_refresh",
+			Help: "Azure Insights metrics cache background refreshes of stale cache entries",
+		},
+		[]string{
+			"handler",
+			"result",
+		},
+	)
+	prometheus.MustRegister(prometheusMetricsCacheRefresh)
+
+	prometheusServiceDiscoveryExcluded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_servicediscovery_excluded",
+			Help: "Azure resources excluded from service discovery by exclusion rules",
+		},
+		[]string{
+			"handler",
+			"reason",
+		},
+	)
+	prometheus.MustRegister(prometheusServiceDiscoveryExcluded)
 }
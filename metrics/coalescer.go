@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type (
+	// ProbeCoalescer deduplicates concurrent identical probes, so they share one collection run
+	ProbeCoalescer struct {
+		lock  sync.Mutex
+		calls map[string]*probeCoalescerCall
+	}
+
+	probeCoalescerCall struct {
+		done       chan struct{}
+		waiters    int
+		metricList *MetricList
+		err        error
+	}
+)
+
+func NewProbeCoalescer() *ProbeCoalescer {
+	return &ProbeCoalescer{
+		calls: map[string]*probeCoalescerCall{},
+	}
+}
+
+// Do runs collect only once for concurrent calls with the same key,
+// coalesced is true if the result of an already running call was used.
+// The collection runs on the context of the first caller (leader), if it is cancelled (eg. client disconnected)
+// waiting callers run collect again (one of them as new leader) instead of failing with the error of the leader.
+func (c *ProbeCoalescer) Do(ctx context.Context, key string, collect func() (*MetricList, error)) (metricList *MetricList, coalesced bool, err error) {
+	c.lock.Lock()
+	for {
+		call, exists := c.calls[key]
+		if !exists {
+			break
+		}
+		call.waiters++
+		c.lock.Unlock()
+
+		select {
+		case <-call.done:
+			if !isContextError(call.err) || ctx.Err() != nil {
+				return call.metricList, true, call.err
+			}
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+
+		c.lock.Lock()
+	}
+
+	call := &probeCoalescerCall{
+		done: make(chan struct{}),
+		// in case collect doesn't return
+		err: errors.New("coalesced probe failed"),
+	}
+	c.calls[key] = call
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		close(call.done)
+	}()
+
+	call.metricList, call.err = collect()
+	return call.metricList, false, call.err
+}
+
+// isContextError returns true if err was caused by a cancelled or expired context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForCall waits until a collection with key is running and the number of waiting callers is reached
+func waitForCall(t *testing.T, c *ProbeCoalescer, key string, waiters int) {
+	t.Helper()
+
+	for i := 0; i < 5000; i++ {
+		c.lock.Lock()
+		call, exists := c.calls[key]
+		ready := exists && call.waiters >= waiters
+		c.lock.Unlock()
+		if ready {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("collection was not started")
+}
+
+func TestProbeCoalescerSharesResult(t *testing.T) {
+	c := NewProbeCoalescer()
+	release := make(chan struct{})
+	var runs atomic.Int32
+
+	collect := func() (*MetricList, error) {
+		runs.Add(1)
+		<-release
+		return NewMetricList(), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]bool, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, coalesced, err := c.Do(context.Background(), "key", collect)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = coalesced
+		}(i)
+		waitForCall(t, c, "key", i)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if runs.Load() != 1 {
+		t.Errorf("expected 1 collection, got %v", runs.Load())
+	}
+
+	coalescedCount := 0
+	for _, coalesced := range results {
+		if coalesced {
+			coalescedCount++
+		}
+	}
+	if coalescedCount != 2 {
+		t.Errorf("expected 2 coalesced probes, got %v", coalescedCount)
+	}
+}
+
+func TestProbeCoalescerLeaderCancelled(t *testing.T) {
+	c := NewProbeCoalescer()
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+
+	leaderDone := make(chan error)
+	go func() {
+		_, _, err := c.Do(leaderCtx, "key", func() (*MetricList, error) {
+			<-leaderCtx.Done()
+			return nil, leaderCtx.Err()
+		})
+		leaderDone <- err
+	}()
+	waitForCall(t, c, "key", 0)
+
+	followerDone := make(chan error)
+	var followerRuns atomic.Int32
+	go func() {
+		metricList, _, err := c.Do(context.Background(), "key", func() (*MetricList, error) {
+			followerRuns.Add(1)
+			return NewMetricList(), nil
+		})
+		if err == nil && metricList == nil {
+			err = errors.New("expected metric list")
+		}
+		followerDone <- err
+	}()
+
+	waitForCall(t, c, "key", 1)
+	cancelLeader()
+
+	if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected leader to fail with context.Canceled, got %v", err)
+	}
+
+	select {
+	case err := <-followerDone:
+		if err != nil {
+			t.Errorf("expected follower to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower did not finish")
+	}
+
+	if followerRuns.Load() != 1 {
+		t.Errorf("expected follower to collect as new leader, got %v collections", followerRuns.Load())
+	}
+}
+
+func TestProbeCoalescerSharesError(t *testing.T) {
+	c := NewProbeCoalescer()
+	release := make(chan struct{})
+	collectErr := errors.New("collect failed")
+
+	leaderDone := make(chan error)
+	go func() {
+		_, _, err := c.Do(context.Background(), "key", func() (*MetricList, error) {
+			<-release
+			return nil, collectErr
+		})
+		leaderDone <- err
+	}()
+	waitForCall(t, c, "key", 0)
+
+	followerDone := make(chan error)
+	go func() {
+		_, _, err := c.Do(context.Background(), "key", func() (*MetricList, error) {
+			return NewMetricList(), nil
+		})
+		followerDone <- err
+	}()
+
+	waitForCall(t, c, "key", 1)
+	close(release)
+
+	if err := <-leaderDone; !errors.Is(err, collectErr) {
+		t.Errorf("expected leader error, got %v", err)
+	}
+	if err := <-followerDone; !errors.Is(err, collectErr) {
+		t.Errorf("expected follower to get error of leader, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+const (
+	// accumulated series are removed if they are not updated (eg. resource was deleted)
+	CumulativeSeriesExpiry = 6 * time.Hour
+)
+
+var (
+	// accumulated values of cumulative series (by series key)
+	cumulativeSeries     = gocache.New(CumulativeSeriesExpiry, 10*time.Minute)
+	cumulativeSeriesLock sync.Mutex
+)
+
+type (
+	cumulativeDatapoint struct {
+		timestamp time.Time
+		value     float64
+	}
+
+	cumulativeSeriesValue struct {
+		value     float64
+		timestamp time.Time
+
+		// start of accumulation (oldest datapoint when the series was created)
+		startTime time.Time
+	}
+)
+
+// isCumulativeAggregation returns true if the aggregation can be accumulated (sum of all datapoints)
+func isCumulativeAggregation(aggregation string) bool {
+	switch aggregation {
+	case "total", "count":
+		return true
+	}
+	return false
+}
+
+// cumulativeSeriesKey returns an unique key of a metric series (name and labels)
+func cumulativeSeriesKey(metric PrometheusMetricResult) string {
+	labelNames := make([]string, 0, len(metric.Labels))
+	for labelName := range metric.Labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	key := strings.Builder{}
+	key.WriteString(metric.Name)
+	for _, labelName := range labelNames {
+		key.WriteString("\xff" + labelName + "=" + metric.Labels[labelName])
+	}
+	return key.String()
+}
+
+// accumulateSeries adds all settled datapoints newer than the last accumulated datapoint of the series and returns the accumulated value
+// and the start of accumulation. Datapoints are settled if their timegrain (requested interval) is finished for at least settleDelay,
+// newer datapoints are skipped as Azure Monitor can still change their values (ingestion delay)
+func accumulateSeries(key string, datapoints []cumulativeDatapoint, timegrain, settleDelay time.Duration) (float64, time.Time) {
+	sort.Slice(datapoints, func(i, j int) bool {
+		return datapoints[i].timestamp.Before(datapoints[j].timestamp)
+	})
+
+	// new series start with the oldest datapoint (also if it's not accumulated yet)
+	startTime := time.Now()
+	if len(datapoints) > 0 {
+		startTime = datapoints[0].timestamp
+	}
+
+	settledBefore := time.Now().Add(-timegrain - settleDelay)
+	settledDatapoints := sort.Search(len(datapoints), func(i int) bool {
+		return datapoints[i].timestamp.After(settledBefore)
+	})
+	datapoints = datapoints[:settledDatapoints]
+
+	cumulativeSeriesLock.Lock()
+	defer cumulativeSeriesLock.Unlock()
+
+	series := cumulativeSeriesValue{startTime: startTime}
+	if val, exists := cumulativeSeries.Get(key); exists {
+		series = val.(cumulativeSeriesValue)
+	}
+
+	for _, datapoint := range datapoints {
+		if datapoint.timestamp.After(series.timestamp) {
+			series.value += datapoint.value
+			series.timestamp = datapoint.timestamp
+		}
+	}
+
+	cumulativeSeries.SetDefault(key, series)
+	return series.value, series.startTime
+}
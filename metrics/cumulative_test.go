@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/webdevops/azure-metrics-exporter/cache"
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+func TestAccumulateSeries(t *testing.T) {
+	key := t.Name()
+	t.Cleanup(func() {
+		cumulativeSeries.Delete(key)
+	})
+
+	now := time.Now().Truncate(time.Minute)
+	timegrain := 5 * time.Minute
+	settleDelay := 10 * time.Minute
+
+	// single datapoint with open timegrain is not accumulated yet
+	if value, _ := accumulateSeries(key, []cumulativeDatapoint{{timestamp: now, value: 1}}, timegrain, settleDelay); value != 0 {
+		t.Errorf("expected 0 (open timegrain), got %v", value)
+	}
+
+	// only settled datapoints are accumulated (finished timegrain older than settle delay)
+	datapoints := []cumulativeDatapoint{
+		{timestamp: now.Add(-30 * time.Minute), value: 1},
+		{timestamp: now.Add(-20 * time.Minute), value: 2},
+		{timestamp: now.Add(-15 * time.Minute), value: 3},
+		{timestamp: now.Add(-10 * time.Minute), value: 4},
+		{timestamp: now, value: 5},
+	}
+	if value, _ := accumulateSeries(key, datapoints, timegrain, settleDelay); value != 6 {
+		t.Errorf("expected 6 (settled datapoints), got %v", value)
+	}
+
+	// datapoints are counted only once, late values of not settled timegrains are accumulated later
+	if value, _ := accumulateSeries(key, datapoints[2:], timegrain, 0); value != 10 {
+		t.Errorf("expected 10, got %v", value)
+	}
+
+	// single settled datapoint, start of accumulation is kept
+	value, startTime := accumulateSeries(key, []cumulativeDatapoint{{timestamp: now, value: 10}}, 1*time.Nanosecond, 0)
+	if value != 20 {
+		t.Errorf("expected 20, got %v", value)
+	}
+	if !startTime.Equal(now) {
+		t.Errorf("expected start time %v (oldest datapoint of first run), got %v", now, startTime)
+	}
+}
+
+func TestMetricRequestTimegrain(t *testing.T) {
+	interval := "PT15M"
+	if timegrain := (&metricRequest{interval: &interval}).timegrain(); timegrain != 15*time.Minute {
+		t.Errorf("expected 15m, got %v", timegrain)
+	}
+
+	if timegrain := (&metricRequest{}).timegrain(); timegrain != AzureMetricIntervalDefault {
+		t.Errorf("expected default interval %v, got %v", AzureMetricIntervalDefault, timegrain)
+	}
+}
+
+func TestCumulativeSharedCacheBackend(t *testing.T) {
+	r := httptest.NewRequest("GET", "/probe/metrics?subscription=xxx&cumulative=true", nil)
+
+	opts := config.Opts{}
+	opts.Prober.CacheBackend = cache.BackendMemory
+	if _, err := NewRequestMetricSettings(r, opts); err != nil {
+		t.Errorf("expected cumulative to be supported with memory cache backend, got %v", err)
+	}
+
+	opts.Prober.CacheBackend = cache.BackendRedis
+	if _, err := NewRequestMetricSettings(r, opts); err == nil {
+		t.Error("expected cumulative to be rejected with redis cache backend")
+	}
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+type (
+	MetricDefinition struct {
+		ResourceType          string   `json:"resourceType"`
+		ResourceID            string   `json:"resourceID"`
+		Namespace             string   `json:"namespace"`
+		Name                  string   `json:"name"`
+		DisplayName           string   `json:"displayName"`
+		Description           string   `json:"description,omitempty"`
+		Category              string   `json:"category,omitempty"`
+		Unit                  string   `json:"unit"`
+		PrimaryAggregation    string   `json:"primaryAggregation"`
+		SupportedAggregations []string `json:"supportedAggregations"`
+		TimeGrains            []string `json:"timeGrains"`
+		Dimensions            []string `json:"dimensions"`
+		IsDimensionRequired   bool     `json:"isDimensionRequired"`
+	}
+
+	// metricDefinitionsEntry fetches the metric definitions of one resource type only once per probe
+	metricDefinitionsEntry struct {
+		once sync.Once
+		list []MetricDefinition
+		err  error
+	}
+)
+
+func (p *MetricProber) MetricDefinitionsClient(subscriptionId string) (*armmonitor.MetricDefinitionsClient, error) {
+	return armmonitor.NewMetricDefinitionsClient(subscriptionId, p.AzureClient.GetCred(), p.AzureClient.NewArmClientOptions())
+}
+
+// FetchMetricDefinitions fetches the metric definitions of all resource types of the targets
+// (metric definitions are the same for all resources of a resource type, so only one resource per type is queried)
+func (p *MetricProber) FetchMetricDefinitions() (list []MetricDefinition, err error) {
+	resourceTypes := map[string]bool{}
+
+	for subscriptionId, targetList := range p.targets {
+		for _, target := range targetList {
+			resourceInfo, err := armclient.ParseResourceId(target.ResourceId)
+			if err != nil {
+				return list, err
+			}
+
+			resourceType := strings.ToLower(resourceInfo.ResourceProviderNamespace + "/" + resourceInfo.ResourceProviderName)
+			if _, exists := resourceTypes[resourceType]; exists {
+				continue
+			}
+			resourceTypes[resourceType] = true
+
+			definitionList, err := p.fetchCachedMetricDefinitions(subscriptionId, target)
+			if err != nil {
+				return list, err
+			}
+			list = append(list, definitionList...)
+		}
+	}
+
+	return
+}
+
+// fetchCachedMetricDefinitions returns the metric definitions of the resource type of the target
+// (cached per resource type and metric namespace)
+func (p *MetricProber) fetchCachedMetricDefinitions(subscriptionId string, target MetricProbeTarget) ([]MetricDefinition, error) {
+	resourceInfo, err := armclient.ParseResourceId(target.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := strings.ToLower(fmt.Sprintf(
+		"metricdefinitions:%v/%v:%v",
+		resourceInfo.ResourceProviderNamespace,
+		resourceInfo.ResourceProviderName,
+		p.settings.MetricNamespace,
+	))
+
+	p.metricDefinitions.lock.Lock()
+	entry, exists := p.metricDefinitions.entries[cacheKey]
+	if !exists {
+		entry = &metricDefinitionsEntry{}
+		p.metricDefinitions.entries[cacheKey] = entry
+	}
+	p.metricDefinitions.lock.Unlock()
+
+	entry.once.Do(func() {
+		if list, ok := p.fetchMetricDefinitionsFromCache(cacheKey); ok {
+			entry.list = list
+			return
+		}
+
+		entry.list, entry.err = p.fetchMetricDefinitionsFromTarget(subscriptionId, target)
+		if entry.err == nil {
+			p.saveMetricDefinitionsToCache(cacheKey, entry.list)
+		}
+	})
+
+	return entry.list, entry.err
+}
+
+func (p *MetricProber) fetchMetricDefinitionsFromCache(cacheKey string) (list []MetricDefinition, status bool) {
+	cache := p.serviceDiscoveryCache.cache
+
+	if cache != nil {
+		if cacheData, ok := cache.Get(cacheKey); ok {
+			if err := json.Unmarshal(cacheData, &list); err == nil {
+				status = true
+			} else {
+				p.logger.Debug("unable to parse cached metric definitions")
+			}
+		}
+	}
+
+	return
+}
+
+func (p *MetricProber) saveMetricDefinitionsToCache(cacheKey string, list []MetricDefinition) {
+	cache := p.serviceDiscoveryCache.cache
+	cacheDuration := p.serviceDiscoveryCache.cacheDuration
+
+	if cache != nil {
+		if cacheData, err := json.Marshal(list); err == nil {
+			if err := cache.Set(cacheKey, cacheData, *cacheDuration); err == nil {
+				p.logger.Debug("saved metric definitions to cache", slog.Duration("cacheDuration", *cacheDuration))
+			} else {
+				p.logger.Warn("unable to save metric definitions to cache", slog.Any("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (p *MetricProber) fetchMetricDefinitionsFromTarget(subscriptionId string, target MetricProbeTarget) (list []MetricDefinition, err error) {
+	p.logger.Debug("fetching metric definitions", slog.String("resourceID", target.ResourceId))
+
+	client, err := p.MetricDefinitionsClient(subscriptionId)
+	if err != nil {
+		return list, err
+	}
+
+	opts := armmonitor.MetricDefinitionsClientListOptions{}
+	if len(p.settings.MetricNamespace) >= 1 {
+		opts.Metricnamespace = to.StringPtr(p.settings.MetricNamespace)
+	}
+
+	pager := client.NewListPager(p.metricResourceURI(target), &opts)
+	for pager.More() {
+		result, err := pager.NextPage(p.ctx)
+		if err != nil {
+			return list, fmt.Errorf(`unable to fetch metric definitions of "%v": %w`, target.ResourceId, err)
+		}
+
+		for _, row := range result.Value {
+			list = append(list, newMetricDefinition(target.ResourceId, row))
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return
+}
+
+func newMetricDefinition(resourceId string, row *armmonitor.MetricDefinition) MetricDefinition {
+	definition := MetricDefinition{
+		ResourceID:            resourceId,
+		Namespace:             to.String(row.Namespace),
+		Description:           to.String(row.DisplayDescription),
+		Category:              to.String(row.Category),
+		SupportedAggregations: []string{},
+		TimeGrains:            []string{},
+		Dimensions:            []string{},
+		IsDimensionRequired:   to.Bool(row.IsDimensionRequired),
+	}
+
+	if resourceInfo, err := armclient.ParseResourceId(resourceId); err == nil {
+		definition.ResourceType = resourceInfo.ResourceProviderNamespace + "/" + resourceInfo.ResourceProviderName
+	}
+
+	if row.Name != nil {
+		definition.Name = to.String(row.Name.Value)
+		definition.DisplayName = to.String(row.Name.LocalizedValue)
+	}
+
+	if row.Unit != nil {
+		definition.Unit = string(*row.Unit)
+	}
+
+	if row.PrimaryAggregationType != nil {
+		definition.PrimaryAggregation = strings.ToLower(string(*row.PrimaryAggregationType))
+	}
+
+	for _, aggregation := range row.SupportedAggregationTypes {
+		if aggregation != nil {
+			definition.SupportedAggregations = append(definition.SupportedAggregations, strings.ToLower(string(*aggregation)))
+		}
+	}
+
+	for _, availability := range row.MetricAvailabilities {
+		if availability != nil && availability.TimeGrain != nil {
+			definition.TimeGrains = append(definition.TimeGrains, to.String(availability.TimeGrain))
+		}
+	}
+
+	for _, dimension := range row.Dimensions {
+		if dimension != nil && dimension.Value != nil {
+			definition.Dimensions = append(definition.Dimensions, to.String(dimension.Value))
+		}
+	}
+
+	return definition
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+const (
+	ExcludeReasonResourceId    = "resourceId"
+	ExcludeReasonTag           = "tag"
+	ExcludeReasonLocation      = "location"
+	ExcludeReasonResourceGroup = "resourceGroup"
+)
+
+type (
+	// ResourceExclusion excludes discovered resources from probing (applied for all service discovery modes)
+	ResourceExclusion struct {
+		// regexps matched against the resource id (case-insensitive)
+		ResourceIds []*regexp.Regexp
+
+		// resource tags (syntax: name or name=value)
+		Tags []ResourceExclusionTag
+
+		// resource locations (eg. westeurope)
+		Locations []string
+
+		// resource groups (with glob support)
+		ResourceGroups []string
+	}
+
+	// ResourceExclusionTag matches a resource tag, an empty value matches every resource with this tag
+	ResourceExclusionTag struct {
+		Name  string
+		Value string
+	}
+)
+
+// IsEnabled returns true if any exclusion rule is set
+func (e *ResourceExclusion) IsEnabled() bool {
+	return len(e.ResourceIds) > 0 || len(e.Tags) > 0 || len(e.Locations) > 0 || len(e.ResourceGroups) > 0
+}
+
+// Match returns the reason (eg. tag) if target is excluded, empty if target is not excluded
+func (e *ResourceExclusion) Match(target *MetricProbeTarget) string {
+	for _, resourceIdRegexp := range e.ResourceIds {
+		if resourceIdRegexp.MatchString(target.ResourceId) {
+			return ExcludeReasonResourceId
+		}
+	}
+
+	// tag rules can only be applied if tags were discovered
+	for _, tag := range e.Tags {
+		for tagName, tagValue := range target.Tags {
+			if strings.EqualFold(tagName, tag.Name) && (tag.Value == "" || strings.EqualFold(tagValue, tag.Value)) {
+				return ExcludeReasonTag
+			}
+		}
+	}
+
+	if target.Location != "" {
+		location := normalizeLocation(target.Location)
+		for _, excludeLocation := range e.Locations {
+			if location == normalizeLocation(excludeLocation) {
+				return ExcludeReasonLocation
+			}
+		}
+	}
+
+	if len(e.ResourceGroups) > 0 {
+		if resourceInfo, err := armclient.ParseResourceId(target.ResourceId); err == nil {
+			for _, pattern := range e.ResourceGroups {
+				if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(resourceInfo.ResourceGroup)); matched {
+					return ExcludeReasonResourceGroup
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// parseResourceExclusionTag parses a tag exclusion (syntax: name or name=value)
+func parseResourceExclusionTag(value string) (tag ResourceExclusionTag, err error) {
+	tag.Name = strings.TrimSpace(value)
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		tag.Name = strings.TrimSpace(parts[0])
+		tag.Value = strings.TrimSpace(parts[1])
+	}
+
+	if tag.Name == "" {
+		return tag, fmt.Errorf(`parameter "excludeTag" has invalid value "%v"`, value)
+	}
+
+	return tag, nil
+}
+
+// normalizeLocation returns the location name (eg. "West Europe" -> westeurope)
+func normalizeLocation(value string) string {
+	return strings.ToLower(strings.ReplaceAll(value, " ", ""))
+}
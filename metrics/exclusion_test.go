@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestResourceExclusionMatch(t *testing.T) {
+	const resourceId = "/subscriptions/sub-a/resourceGroups/RG-Prod-Web/providers/Microsoft.Web/sites/app-1"
+
+	testCases := []struct {
+		name      string
+		exclusion ResourceExclusion
+		target    MetricProbeTarget
+		expected  string
+	}{
+		{
+			name:     "no rules",
+			target:   MetricProbeTarget{ResourceId: resourceId},
+			expected: "",
+		},
+		{
+			name:      "resourceId case-insensitive",
+			exclusion: ResourceExclusion{ResourceIds: []*regexp.Regexp{regexp.MustCompile("(?i)/sites/APP-1$")}},
+			target:    MetricProbeTarget{ResourceId: resourceId},
+			expected:  ExcludeReasonResourceId,
+		},
+		{
+			name:      "resourceId no match",
+			exclusion: ResourceExclusion{ResourceIds: []*regexp.Regexp{regexp.MustCompile("(?i)/sites/app-2$")}},
+			target:    MetricProbeTarget{ResourceId: resourceId},
+			expected:  "",
+		},
+		{
+			name:      "tag name",
+			exclusion: ResourceExclusion{Tags: []ResourceExclusionTag{{Name: "monitoring"}}},
+			target:    MetricProbeTarget{ResourceId: resourceId, Tags: map[string]string{"Monitoring": "disabled"}},
+			expected:  ExcludeReasonTag,
+		},
+		{
+			name:      "tag name and value",
+			exclusion: ResourceExclusion{Tags: []ResourceExclusionTag{{Name: "monitoring", Value: "DISABLED"}}},
+			target:    MetricProbeTarget{ResourceId: resourceId, Tags: map[string]string{"monitoring": "disabled"}},
+			expected:  ExcludeReasonTag,
+		},
+		{
+			name:      "tag value differs",
+			exclusion: ResourceExclusion{Tags: []ResourceExclusionTag{{Name: "monitoring", Value: "disabled"}}},
+			target:    MetricProbeTarget{ResourceId: resourceId, Tags: map[string]string{"monitoring": "enabled"}},
+			expected:  "",
+		},
+		{
+			name:      "tag without discovered tags",
+			exclusion: ResourceExclusion{Tags: []ResourceExclusionTag{{Name: "monitoring"}}},
+			target:    MetricProbeTarget{ResourceId: resourceId},
+			expected:  "",
+		},
+		{
+			name:      "location display name",
+			exclusion: ResourceExclusion{Locations: []string{"westeurope"}},
+			target:    MetricProbeTarget{ResourceId: resourceId, Location: "West Europe"},
+			expected:  ExcludeReasonLocation,
+		},
+		{
+			name:      "location without discovered location",
+			exclusion: ResourceExclusion{Locations: []string{"westeurope"}},
+			target:    MetricProbeTarget{ResourceId: resourceId},
+			expected:  "",
+		},
+		{
+			name:      "resourceGroup glob case-insensitive",
+			exclusion: ResourceExclusion{ResourceGroups: []string{"rg-prod-*"}},
+			target:    MetricProbeTarget{ResourceId: resourceId},
+			expected:  ExcludeReasonResourceGroup,
+		},
+		{
+			name:      "resourceGroup no match",
+			exclusion: ResourceExclusion{ResourceGroups: []string{"rg-dev-*"}},
+			target:    MetricProbeTarget{ResourceId: resourceId},
+			expected:  "",
+		},
+		{
+			name:      "resourceGroup invalid resource id",
+			exclusion: ResourceExclusion{ResourceGroups: []string{"*"}},
+			target:    MetricProbeTarget{ResourceId: "invalid"},
+			expected:  "",
+		},
+		{
+			name: "resourceId before tag",
+			exclusion: ResourceExclusion{
+				ResourceIds: []*regexp.Regexp{regexp.MustCompile("(?i)app-1")},
+				Tags:        []ResourceExclusionTag{{Name: "monitoring"}},
+			},
+			target:   MetricProbeTarget{ResourceId: resourceId, Tags: map[string]string{"monitoring": ""}},
+			expected: ExcludeReasonResourceId,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if reason := tc.exclusion.Match(&tc.target); reason != tc.expected {
+				t.Errorf("expected reason %q, got %q", tc.expected, reason)
+			}
+		})
+	}
+}
+
+func TestParseResourceExclusionTag(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected ResourceExclusionTag
+		error    bool
+	}{
+		{value: "monitoring", expected: ResourceExclusionTag{Name: "monitoring"}},
+		{value: " monitoring = disabled ", expected: ResourceExclusionTag{Name: "monitoring", Value: "disabled"}},
+		{value: "monitoring=", expected: ResourceExclusionTag{Name: "monitoring"}},
+		{value: "url=a=b", expected: ResourceExclusionTag{Name: "url", Value: "a=b"}},
+		{value: "=disabled", error: true},
+		{value: " ", error: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			tag, err := parseResourceExclusionTag(tc.value)
+			if tc.error {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", tag)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if tag != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, tag)
+			}
+		})
+	}
+}
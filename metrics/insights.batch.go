@@ -0,0 +1,383 @@
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/query/azmetrics"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	iso8601 "github.com/channelmeter/iso8601duration"
+	"github.com/remeh/sizedwaitgroup"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	AzureMetricBatchApiMaxResourceNumber = 50
+
+	azureMetricBatchApiTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+type (
+	// metricBatch is a list of targets which can be requested with one metrics:getBatch call
+	// (same subscription, region, resource type and metric settings)
+	metricBatch struct {
+		subscriptionId  string
+		region          string
+		metricNamespace string
+		request         metricRequest
+		targets         []MetricProbeTarget
+	}
+
+	// metricBatchTargetStatus is the collection status of a target over all batches
+	metricBatchTargetStatus struct {
+		subscriptionId string
+		duration       time.Duration
+		err            error
+	}
+)
+
+// MetricsBatchClient returns a client for the regional Azure Monitor metrics batch api (metrics:getBatch)
+func (p *MetricProber) MetricsBatchClient(region string) (*azmetrics.Client, error) {
+	serviceConfig, exists := p.AzureClient.GetCloudConfig().Services[azmetrics.ServiceName]
+	if !exists || serviceConfig.Audience == "" {
+		return nil, fmt.Errorf("azure cloud configuration has no endpoint for metrics batch api")
+	}
+
+	endpoint, err := url.Parse(serviceConfig.Audience)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Host = strings.ToLower(region) + "." + endpoint.Host
+
+	clientOpts := azmetrics.ClientOptions{ClientOptions: *p.AzureClient.NewAzCoreClientOptions()}
+	clientOpts.PerCallPolicies = append(
+		clientOpts.PerCallPolicies,
+		noCachePolicy{},
+	)
+	return azmetrics.NewClient(endpoint.String(), p.AzureClient.GetCred(), &clientOpts)
+}
+
+func (p *MetricProber) collectMetricsFromTargetsBatch() {
+	metricsChannel := make(chan PrometheusMetricResult)
+
+	go func() {
+		p.sendDiscoveryStatusToChannel(metricsChannel)
+
+		wgBatch := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscriptionResource)
+
+		// targets can be part of multiple batches, status is reported once per target
+		var targetStatusLock sync.Mutex
+		targetStatus := map[string]*metricBatchTargetStatus{}
+		targetStatusOrder := []string{}
+
+		for _, batch := range p.buildMetricBatches(metricsChannel) {
+			for _, target := range batch.targets {
+				if _, exists := targetStatus[target.ResourceId]; !exists {
+					targetStatus[target.ResourceId] = &metricBatchTargetStatus{subscriptionId: batch.subscriptionId}
+					targetStatusOrder = append(targetStatusOrder, target.ResourceId)
+				}
+			}
+
+			wgBatch.Add()
+			go func(batch *metricBatch) {
+				defer wgBatch.Done()
+				duration, targetErrors := p.collectMetricBatch(batch, metricsChannel)
+
+				targetStatusLock.Lock()
+				defer targetStatusLock.Unlock()
+				for _, target := range batch.targets {
+					status := targetStatus[target.ResourceId]
+					status.duration += duration
+					if err := targetErrors[target.ResourceId]; err != nil {
+						status.err = err
+					}
+				}
+			}(batch)
+		}
+		wgBatch.Wait()
+
+		for _, resourceId := range targetStatusOrder {
+			status := targetStatus[resourceId]
+			p.sendTargetStatusToChannel(metricsChannel, resourceId, status.subscriptionId, "", status.duration, status.err)
+		}
+
+		if p.callbackSubscriptionFishish != nil {
+			for subscriptionId := range p.targets {
+				p.callbackSubscriptionFishish(subscriptionId)
+			}
+		}
+
+		close(metricsChannel)
+	}()
+
+	p.receiveMetricsFromChannel(metricsChannel)
+}
+
+// buildMetricBatches groups all targets by subscription, region, resource type and metric request
+func (p *MetricProber) buildMetricBatches(metricsChannel chan<- PrometheusMetricResult) (batchList []*metricBatch) {
+	batchGroups := map[string]*metricBatch{}
+	batchGroupOrder := []string{}
+
+	for subscriptionId, targetList := range p.targets {
+		for _, target := range targetList {
+			resourceInfo, err := armclient.ParseResourceId(target.ResourceId)
+			if err != nil {
+				p.sendTargetStatusToChannel(metricsChannel, target.ResourceId, subscriptionId, "", 0, err)
+				continue
+			}
+
+			// region is needed for the regional batch api endpoint
+			if target.Location == "" {
+				resource, err := p.AzureClient.GetCachedResource(p.ctx, target.ResourceId)
+				if err != nil {
+					p.logger.With(slog.String("resourceID", target.ResourceId)).Warn(err.Error())
+					p.sendTargetStatusToChannel(metricsChannel, target.ResourceId, subscriptionId, "", 0, err)
+					continue
+				}
+				target.Location = to.String(resource.Location)
+			}
+
+			metricNamespace := p.settings.MetricNamespace
+			if metricNamespace == "" {
+				metricNamespace = resourceInfo.ResourceProviderNamespace + "/" + resourceInfo.ResourceProviderName
+			}
+
+			requestList, err := p.targetMetricRequests(subscriptionId, target)
+			if err != nil {
+				p.logger.With(slog.String("resourceID", target.ResourceId)).Warn(err.Error())
+				p.sendTargetStatusToChannel(metricsChannel, target.ResourceId, subscriptionId, "", 0, err)
+				continue
+			} else if len(requestList) == 0 {
+				p.sendTargetStatusToChannel(metricsChannel, target.ResourceId, subscriptionId, "", 0, nil)
+				continue
+			}
+
+			for _, request := range requestList {
+				groupKey := strings.ToLower(strings.Join(
+					[]string{
+						subscriptionId,
+						target.Location,
+						metricNamespace,
+						strings.Join(request.metrics, ","),
+						strings.Join(request.aggregations, ","),
+						to.String(request.interval),
+						request.timespan,
+					},
+					"|",
+				))
+
+				if _, exists := batchGroups[groupKey]; !exists {
+					batchGroups[groupKey] = &metricBatch{
+						subscriptionId:  subscriptionId,
+						region:          target.Location,
+						metricNamespace: metricNamespace,
+						request:         request,
+					}
+					batchGroupOrder = append(batchGroupOrder, groupKey)
+				}
+				batchGroups[groupKey].targets = append(batchGroups[groupKey].targets, target)
+			}
+		}
+	}
+
+	// split groups into batches (azure metric batch api limitation)
+	for _, groupKey := range batchGroupOrder {
+		group := batchGroups[groupKey]
+		for i := 0; i < len(group.targets); i += AzureMetricBatchApiMaxResourceNumber {
+			end := i + AzureMetricBatchApiMaxResourceNumber
+			if end > len(group.targets) {
+				end = len(group.targets)
+			}
+
+			batch := *group
+			batch.targets = group.targets[i:end]
+			batchList = append(batchList, &batch)
+		}
+	}
+
+	return
+}
+
+// collectMetricBatch requests the metrics of one batch and returns its duration and the errors per target
+func (p *MetricProber) collectMetricBatch(batch *metricBatch, metricsChannel chan<- PrometheusMetricResult) (time.Duration, map[string]error) {
+	batchStartTime := time.Now()
+	contextLogger := p.logger.With(
+		slog.String("subscriptionID", batch.subscriptionId),
+		slog.String("region", batch.region),
+		slog.String("metricNamespace", batch.metricNamespace),
+	)
+
+	targetErrors := map[string]error{}
+	targetsByResourceURI := map[string]MetricProbeTarget{}
+	resourceIdList := []string{}
+	for _, target := range batch.targets {
+		resourceURI := p.metricResourceURI(target)
+		targetsByResourceURI[strings.ToLower(resourceURI)] = target
+		resourceIdList = append(resourceIdList, resourceURI)
+	}
+
+	setBatchError := func(err error) {
+		for _, target := range batch.targets {
+			targetErrors[target.ResourceId] = err
+		}
+	}
+
+	if client, err := p.MetricsBatchClient(batch.region); err == nil {
+		if opts, err := p.metricBatchQueryOptions(batch); err == nil {
+			response, err := client.QueryResources(
+				p.ctx,
+				batch.subscriptionId,
+				batch.metricNamespace,
+				batch.request.metrics,
+				azmetrics.ResourceIDList{ResourceIDs: resourceIdList},
+				&opts,
+			)
+			if err != nil {
+				contextLogger.Warn(err.Error())
+				setBatchError(err)
+				return time.Since(batchStartTime), targetErrors
+			}
+
+			for _, metricData := range response.Values {
+				target, exists := targetsByResourceURI[strings.ToLower(to.String(metricData.ResourceID))]
+				if !exists {
+					continue
+				}
+
+				result := AzureInsightMetricsResult{
+					AzureInsightBaseMetricsResult: AzureInsightBaseMetricsResult{
+						prober:  p,
+						request: &batch.request,
+					},
+					target: &target,
+					Result: &armmonitor.MetricsClientListResponse{
+						Response: armmonitor.Response{
+							Value: batchMetricsToArmMetrics(metricData.Values),
+						},
+					},
+				}
+				result.SendMetricToChannel(metricsChannel)
+			}
+		} else {
+			contextLogger.Error(err.Error())
+			setBatchError(err)
+		}
+	} else {
+		contextLogger.Error(err.Error())
+		setBatchError(err)
+	}
+
+	return time.Since(batchStartTime), targetErrors
+}
+
+func (p *MetricProber) metricBatchQueryOptions(batch *metricBatch) (azmetrics.QueryResourcesOptions, error) {
+	opts := azmetrics.QueryResourcesOptions{
+		Interval: batch.request.interval,
+		Top:      p.settings.MetricTop,
+	}
+
+	// batch api needs start and end time instead of timespan
+	startTime, endTime, err := timespanToTimeRange(batch.request.timespan, time.Now())
+	if err != nil {
+		return opts, err
+	}
+	opts.StartTime = to.StringPtr(startTime.UTC().Format(azureMetricBatchApiTimeFormat))
+	opts.EndTime = to.StringPtr(endTime.UTC().Format(azureMetricBatchApiTimeFormat))
+
+	if len(batch.request.aggregations) >= 1 {
+		opts.Aggregation = to.StringPtr(strings.Join(batch.request.aggregations, ","))
+	}
+
+	if len(p.settings.MetricFilter) >= 1 {
+		opts.Filter = to.StringPtr(p.settings.MetricFilter)
+	}
+
+	if len(p.settings.MetricOrderBy) >= 1 {
+		opts.OrderBy = to.StringPtr(p.settings.MetricOrderBy)
+	}
+
+	return opts, nil
+}
+
+// timespanToTimeRange converts an Azure timespan (ISO8601 duration or start/end) into start and end time
+func timespanToTimeRange(timespan string, now time.Time) (startTime, endTime time.Time, err error) {
+	if parts := strings.SplitN(timespan, "/", 2); len(parts) == 2 {
+		if startTime, err = time.Parse(time.RFC3339, parts[0]); err != nil {
+			return
+		}
+		endTime, err = time.Parse(time.RFC3339, parts[1])
+		return
+	}
+
+	duration, err := iso8601.FromString(timespan)
+	if err != nil {
+		return startTime, endTime, fmt.Errorf(`unable to parse timespan "%v": %w`, timespan, err)
+	}
+
+	endTime = now
+	startTime = now.Add(-duration.ToDuration())
+	return
+}
+
+// batchMetricsToArmMetrics converts metrics batch api results to armmonitor metrics (for reuse of the metric result processing)
+func batchMetricsToArmMetrics(metrics []azmetrics.Metric) (list []*armmonitor.Metric) {
+	for _, metric := range metrics {
+		armMetric := armmonitor.Metric{
+			ID:           metric.ID,
+			Type:         metric.Type,
+			ErrorCode:    metric.ErrorCode,
+			ErrorMessage: metric.ErrorMessage,
+		}
+
+		if metric.Name != nil {
+			armMetric.Name = &armmonitor.LocalizableString{
+				Value:          metric.Name.Value,
+				LocalizedValue: metric.Name.LocalizedValue,
+			}
+		}
+
+		if metric.Unit != nil {
+			unit := armmonitor.Unit(*metric.Unit)
+			armMetric.Unit = &unit
+		}
+
+		for _, timeseries := range metric.TimeSeries {
+			armTimeseries := armmonitor.TimeSeriesElement{}
+
+			for _, metadata := range timeseries.MetadataValues {
+				armMetadata := armmonitor.MetadataValue{
+					Value: metadata.Value,
+				}
+				if metadata.Name != nil {
+					armMetadata.Name = &armmonitor.LocalizableString{
+						Value:          metadata.Name.Value,
+						LocalizedValue: metadata.Name.LocalizedValue,
+					}
+				}
+				armTimeseries.Metadatavalues = append(armTimeseries.Metadatavalues, &armMetadata)
+			}
+
+			for _, data := range timeseries.Data {
+				armTimeseries.Data = append(armTimeseries.Data, &armmonitor.MetricValue{
+					TimeStamp: data.TimeStamp,
+					Average:   data.Average,
+					Count:     data.Count,
+					Maximum:   data.Maximum,
+					Minimum:   data.Minimum,
+					Total:     data.Total,
+				})
+			}
+
+			armMetric.Timeseries = append(armMetric.Timeseries, &armTimeseries)
+		}
+
+		list = append(list, &armMetric)
+	}
+
+	return
+}
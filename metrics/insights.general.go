@@ -2,13 +2,16 @@ package metrics
 
 import (
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type (
 	AzureInsightBaseMetricsResult struct {
-		prober *MetricProber
+		prober  *MetricProber
+		request *metricRequest
 	}
 )
 
@@ -19,10 +22,29 @@ func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, va
 		metricLabels[labelName] = labelValue
 	}
 
+	// count aggregation is the number of datapoints (not a value in the unit of the metric)
+	if metricLabels["aggregation"] == "count" {
+		metricLabels["unit"] = string(armmonitor.UnitCount)
+	}
+
+	// convert value to prometheus base unit (unit is used as label and in template)
+	if r.prober.settings.NormalizeUnits {
+		unit, factor := normalizeUnit(metricLabels["unit"])
+		metricLabels["unit"] = unit
+		value *= factor
+	}
+	metricUnit := metricLabels["unit"]
+
 	metric = PrometheusMetricResult{
-		Name:   r.prober.settings.MetricTemplate,
-		Labels: metricLabels,
-		Value:  value,
+		Name:      r.prober.settings.MetricTemplate,
+		Labels:    metricLabels,
+		Value:     value,
+		AzureUnit: metricUnit,
+	}
+
+	// normalized count unit is empty (OTLP unit of counts is "1")
+	if metricLabels["aggregation"] == "count" {
+		metric.AzureUnit = string(armmonitor.UnitCount)
 	}
 
 	// fallback if template is empty (should not be)
@@ -80,10 +102,156 @@ func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, va
 		)
 	}
 
-	// sanitize metric name
-	metric.Name = metricNameReplacer.Replace(metric.Name)
-	metric.Name = strings.ToLower(metric.Name)
-	metric.Name = metricNameNotAllowedChars.ReplaceAllString(metric.Name, "")
+	metric.Name = sanitizeMetricName(metric.Name)
+
+	// remove separators of empty placeholders (eg. {unit} of metrics without unit)
+	if r.prober.settings.NormalizeUnits {
+		metric.Name = strings.Trim(metricNameDuplicateSeparator.ReplaceAllString(metric.Name, "_"), "_")
+	}
+
+	// unit metadata (OpenMetrics) is only valid if the metric name ends with the unit (eg. template "{name}_{metric}_{unit}")
+	if unit := sanitizeMetricName(metricUnit); unit != "" && strings.HasSuffix(metric.Name, "_"+unit) {
+		metric.Unit = unit
+	}
+
+	if !r.prober.settings.UnitLabel {
+		delete(metric.Labels, "unit")
+	}
 
 	return
 }
+
+// sanitizeMetricName returns a valid (lowercase) prometheus metric name
+func sanitizeMetricName(name string) string {
+	name = metricNameReplacer.Replace(name)
+	name = strings.ToLower(name)
+	return metricNameNotAllowedChars.ReplaceAllString(name, "")
+}
+
+// sendCumulativeDataToChannel accumulates the datapoints of total and count aggregations and sends them as counters
+func (r *AzureInsightBaseMetricsResult) sendCumulativeDataToChannel(channel chan<- PrometheusMetricResult, metricLabels prometheus.Labels, data []*armmonitor.MetricValue) {
+	for _, aggregation := range []string{"total", "count"} {
+		var datapoints []cumulativeDatapoint
+		for _, timeseriesData := range data {
+			if timeseriesData.TimeStamp == nil {
+				continue
+			}
+
+			value := timeseriesData.Total
+			if aggregation == "count" {
+				value = timeseriesData.Count
+			}
+
+			if value != nil {
+				datapoints = append(datapoints, cumulativeDatapoint{timestamp: *timeseriesData.TimeStamp, value: *value})
+			}
+		}
+
+		if len(datapoints) == 0 {
+			continue
+		}
+
+		metricLabels["aggregation"] = aggregation
+		// value 1 returns the unit conversion factor for the accumulated value
+		metric := r.buildMetric(metricLabels, 1)
+		unitFactor := metric.Value
+		if !strings.HasSuffix(metric.Name, "_total") {
+			// counters are separate metrics with _total suffix (prometheus naming convention, required by OpenMetrics)
+			metric.Name += "_total"
+		}
+		value, startTime := accumulateSeries(cumulativeSeriesKey(metric), datapoints, r.request.timegrain(), r.prober.Conf.Metrics.Cumulative.SettleDelay)
+		metric.Value = value * unitFactor
+		metric.Counter = true
+		metric.StartTimestamp = &startTime
+		channel <- metric
+	}
+}
+
+// sendTimeseriesDataToChannel sends the datapoints of one timeseries (depending on the datapoint mode)
+func (r *AzureInsightBaseMetricsResult) sendTimeseriesDataToChannel(channel chan<- PrometheusMetricResult, metricLabels prometheus.Labels, data []*armmonitor.MetricValue) {
+	type aggregationValue struct {
+		aggregation string
+		value       *float64
+	}
+
+	aggregationValues := func(timeseriesData *armmonitor.MetricValue) []aggregationValue {
+		ret := []aggregationValue{
+			{"total", timeseriesData.Total},
+			{"minimum", timeseriesData.Minimum},
+			{"maximum", timeseriesData.Maximum},
+			{"average", timeseriesData.Average},
+			{"count", timeseriesData.Count},
+		}
+
+		// cumulative aggregations are sent as counters (see below)
+		if r.prober.settings.Cumulative {
+			for i := range ret {
+				if isCumulativeAggregation(ret[i].aggregation) {
+					ret[i].value = nil
+				}
+			}
+		}
+
+		return ret
+	}
+
+	if r.prober.settings.Cumulative {
+		r.sendCumulativeDataToChannel(channel, metricLabels, data)
+	}
+
+	switch r.prober.settings.Datapoint {
+	case DatapointModeLast:
+		// only use newest non-null datapoint per aggregation
+		type datapoint struct {
+			value     float64
+			timestamp *time.Time
+		}
+		latestDatapoints := map[string]datapoint{}
+		latestAggregations := []string{}
+		for _, timeseriesData := range data {
+			for _, row := range aggregationValues(timeseriesData) {
+				if row.value == nil {
+					continue
+				}
+
+				if latest, exists := latestDatapoints[row.aggregation]; !exists {
+					latestAggregations = append(latestAggregations, row.aggregation)
+				} else if latest.timestamp != nil && timeseriesData.TimeStamp != nil && latest.timestamp.After(*timeseriesData.TimeStamp) {
+					continue
+				}
+
+				latestDatapoints[row.aggregation] = datapoint{value: *row.value, timestamp: timeseriesData.TimeStamp}
+			}
+		}
+
+		for _, aggregation := range latestAggregations {
+			metricLabels["aggregation"] = aggregation
+			channel <- r.buildMetric(
+				metricLabels,
+				latestDatapoints[aggregation].value,
+			)
+		}
+
+	default:
+		for _, timeseriesData := range data {
+			for _, row := range aggregationValues(timeseriesData) {
+				if row.value == nil {
+					continue
+				}
+
+				metricLabels["aggregation"] = row.aggregation
+				metric := r.buildMetric(
+					metricLabels,
+					*row.value,
+				)
+
+				// expose every datapoint with Azure timestamp
+				if r.prober.settings.Datapoint == DatapointModeTimestamp {
+					metric.Timestamp = timeseriesData.TimeStamp
+				}
+
+				channel <- metric
+			}
+		}
+	}
+}
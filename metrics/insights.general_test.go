@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+func newTestInsightResult(settings *RequestMetricSettings) *AzureInsightBaseMetricsResult {
+	return &AzureInsightBaseMetricsResult{
+		prober: &MetricProber{settings: settings},
+	}
+}
+
+func TestBuildMetricUnit(t *testing.T) {
+	testCases := []struct {
+		name           string
+		aggregation    string
+		normalizeUnits bool
+		metricName     string
+		value          float64
+		unit           string
+		azureUnit      string
+	}{
+		{"average", "average", false, "azurerm_test_latency_milliseconds", 1500, "milliseconds", "MilliSeconds"},
+		{"average normalized", "average", true, "azurerm_test_latency_seconds", 1.5, "seconds", "seconds"},
+		{"count", "count", false, "azurerm_test_latency_count", 1500, "count", "Count"},
+		{"count normalized", "count", true, "azurerm_test_latency", 1500, "", "Count"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestInsightResult(&RequestMetricSettings{
+				Name:           "azurerm_test",
+				MetricTemplate: "{name}_{metric}_{unit}",
+				NormalizeUnits: tc.normalizeUnits,
+			})
+
+			metric := r.buildMetric(prometheus.Labels{"metric": "latency", "aggregation": tc.aggregation, "unit": "MilliSeconds"}, 1500)
+
+			if metric.Name != tc.metricName {
+				t.Errorf("expected name %q, got %q", tc.metricName, metric.Name)
+			}
+			if metric.Value != tc.value {
+				t.Errorf("expected value %v, got %v", tc.value, metric.Value)
+			}
+			if metric.Unit != tc.unit {
+				t.Errorf("expected unit %q, got %q", tc.unit, metric.Unit)
+			}
+			if metric.AzureUnit != tc.azureUnit {
+				t.Errorf("expected azure unit %q, got %q", tc.azureUnit, metric.AzureUnit)
+			}
+		})
+	}
+}
+
+func TestBuildMetricCountUnitLabel(t *testing.T) {
+	for normalizeUnits, expected := range map[bool]string{false: "Count", true: ""} {
+		r := newTestInsightResult(&RequestMetricSettings{
+			Name:           "azurerm_test",
+			MetricTemplate: "{name}_{metric}",
+			NormalizeUnits: normalizeUnits,
+			UnitLabel:      true,
+		})
+
+		metric := r.buildMetric(prometheus.Labels{"metric": "latency", "aggregation": "count", "unit": "MilliSeconds"}, 10)
+		if metric.Labels["unit"] != expected {
+			t.Errorf("normalizeUnits=%v: expected unit label %q, got %q", normalizeUnits, expected, metric.Labels["unit"])
+		}
+		if metric.Unit != "" {
+			t.Errorf("normalizeUnits=%v: expected no unit metadata, got %q", normalizeUnits, metric.Unit)
+		}
+	}
+}
+
+func TestSendTimeseriesDataToChannelDatapointModes(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	t2 := t0.Add(2 * time.Minute)
+
+	// unordered datapoints, newest average is null
+	data := []*armmonitor.MetricValue{
+		{TimeStamp: &t1, Average: to.Ptr(2.0), Count: to.Ptr(20.0)},
+		{TimeStamp: &t2, Count: to.Ptr(30.0)},
+		{TimeStamp: &t0, Average: to.Ptr(1.0), Count: to.Ptr(10.0)},
+	}
+
+	testCases := []struct {
+		datapoint string
+		expected  []string
+	}{
+		{
+			datapoint: DatapointModeDefault,
+			expected:  []string{"average=1", "average=2", "count=10", "count=20", "count=30"},
+		},
+		{
+			datapoint: DatapointModeLast,
+			expected:  []string{"average=2", "count=30"},
+		},
+		{
+			datapoint: DatapointModeTimestamp,
+			expected: []string{
+				"average=1@" + t0.Format(time.RFC3339),
+				"average=2@" + t1.Format(time.RFC3339),
+				"count=10@" + t0.Format(time.RFC3339),
+				"count=20@" + t1.Format(time.RFC3339),
+				"count=30@" + t2.Format(time.RFC3339),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run("datapoint="+tc.datapoint, func(t *testing.T) {
+			r := newTestInsightResult(&RequestMetricSettings{
+				Name:           "azurerm_test",
+				MetricTemplate: "{name}",
+				Datapoint:      tc.datapoint,
+			})
+
+			channel := make(chan PrometheusMetricResult, 10)
+			r.sendTimeseriesDataToChannel(channel, prometheus.Labels{"metric": "requests"}, data)
+			close(channel)
+
+			result := []string{}
+			for metric := range channel {
+				value := fmt.Sprintf("%v=%v", metric.Labels["aggregation"], metric.Value)
+				if metric.Timestamp != nil {
+					value += "@" + metric.Timestamp.Format(time.RFC3339)
+				}
+				result = append(result, value)
+			}
+			sort.Strings(result)
+
+			if strings.Join(result, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,18 +12,32 @@ import (
 )
 
 var (
-	metricNamePlaceholders     = regexp.MustCompile(`{([^}]+)}`)
-	metricNameNotAllowedChars  = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
-	metricLabelNotAllowedChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
-	metricNameReplacer         = strings.NewReplacer("-", "_", " ", "_", "/", "_", ".", "_")
+	metricNamePlaceholders       = regexp.MustCompile(`{([^}]+)}`)
+	metricNameNotAllowedChars    = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	metricLabelNotAllowedChars   = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	metricNameReplacer           = strings.NewReplacer("-", "_", " ", "_", "/", "_", ".", "_")
+	metricNameDuplicateSeparator = regexp.MustCompile(`__+`)
 )
 
 type (
 	PrometheusMetricResult struct {
-		Name   string
-		Labels prometheus.Labels
-		Value  float64
-		Help   string
+		Name      string
+		Labels    prometheus.Labels
+		Value     float64
+		Timestamp *time.Time
+		Help      string
+
+		// unit (only set if the metric name ends with the unit)
+		Unit string
+
+		// unit of the value as reported by Azure Monitor (converted with normalizeUnits)
+		AzureUnit string
+
+		// value is cumulative (counter)
+		Counter bool
+
+		// start of accumulation of cumulative values (counter)
+		StartTimestamp *time.Time
 	}
 )
 
@@ -35,27 +50,28 @@ func (p *MetricProber) MetricsClient(subscriptionId string) (*armmonitor.Metrics
 	return armmonitor.NewMetricsClient(subscriptionId, p.AzureClient.GetCred(), clientOpts)
 }
 
-func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient, target MetricProbeTarget, metrics, aggregations []string) (AzureInsightMetricsResult, error) {
+func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient, target MetricProbeTarget, request metricRequest) (AzureInsightMetricsResult, error) {
 	ret := AzureInsightMetricsResult{
 		AzureInsightBaseMetricsResult: AzureInsightBaseMetricsResult{
-			prober: p,
+			prober:  p,
+			request: &request,
 		},
 		target: &target,
 	}
 
 	resultType := armmonitor.ResultTypeData
 	opts := armmonitor.MetricsClientListOptions{
-		Interval:            p.settings.Interval,
+		Interval:            request.interval,
 		ResultType:          &resultType,
-		Timespan:            to.StringPtr(p.settings.Timespan),
-		Metricnames:         to.StringPtr(strings.Join(metrics, ",")),
+		Timespan:            to.StringPtr(request.timespan),
+		Metricnames:         to.StringPtr(strings.Join(request.metrics, ",")),
 		Top:                 p.settings.MetricTop,
 		AutoAdjustTimegrain: to.BoolPtr(true),
 		ValidateDimensions:  to.BoolPtr(p.settings.ValidateDimensions),
 	}
 
-	if len(aggregations) >= 1 {
-		opts.Aggregation = to.StringPtr(strings.Join(aggregations, ","))
+	if len(request.aggregations) >= 1 {
+		opts.Aggregation = to.StringPtr(strings.Join(request.aggregations, ","))
 	}
 
 	if len(p.settings.MetricFilter) >= 1 {
@@ -70,17 +86,9 @@ func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient,
 		opts.Orderby = to.StringPtr(p.settings.MetricOrderBy)
 	}
 
-	resourceURI := target.ResourceId
-	if strings.HasPrefix(strings.ToLower(p.settings.MetricNamespace), "microsoft.storage/storageaccounts/") {
-		splitNamespace := strings.Split(p.settings.MetricNamespace, "/")
-		// Storage accounts have an extra requirement that their ResourceURI include <type>/default
-		storageAccountType := splitNamespace[len(splitNamespace)-1]
-		resourceURI = resourceURI + fmt.Sprintf("/%s/default", storageAccountType)
-	}
-
 	result, err := client.List(
 		p.ctx,
-		resourceURI,
+		p.metricResourceURI(target),
 		&opts,
 	)
 
@@ -90,3 +98,15 @@ func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient,
 
 	return ret, err
 }
+
+// metricResourceURI returns the resource uri for metric requests of a target
+func (p *MetricProber) metricResourceURI(target MetricProbeTarget) string {
+	resourceURI := target.ResourceId
+	if strings.HasPrefix(strings.ToLower(p.settings.MetricNamespace), "microsoft.storage/storageaccounts/") {
+		splitNamespace := strings.Split(p.settings.MetricNamespace, "/")
+		// Storage accounts have an extra requirement that their ResourceURI include <type>/default
+		storageAccountType := splitNamespace[len(splitNamespace)-1]
+		resourceURI = resourceURI + fmt.Sprintf("/%s/default", storageAccountType)
+	}
+	return resourceURI
+}
@@ -65,13 +65,15 @@ func (r *AzureInsightSubscriptionMetricsResult) SendMetricToChannel(channel chan
 							"resourceName":     azureResource.ResourceName,
 							"metric":           to.String(metric.Name.Value),
 							"unit":             metricUnit,
-							"interval":         to.String(r.prober.settings.Interval),
-							"timespan":         r.prober.settings.Timespan,
+							"interval":         to.String(r.request.interval),
+							"timespan":         r.request.timespan,
 							"aggregation":      "",
 						}
 
 						// add resource tags as labels
 						metricLabels = r.prober.AzureResourceTagManager.AddResourceTagsToPrometheusLabels(r.prober.ctx, metricLabels, resourceId)
+						r.prober.addManagementGroupLabel(metricLabels, azureResource.Subscription)
+						r.prober.addSubscriptionTagLabels(metricLabels, r.subscription)
 
 						if len(dimensions) == 1 {
 							// we have only one dimension
@@ -89,47 +91,7 @@ func (r *AzureInsightSubscriptionMetricsResult) SendMetricToChannel(channel chan
 							}
 						}
 
-						for _, timeseriesData := range timeseries.Data {
-							if timeseriesData.Total != nil {
-								metricLabels["aggregation"] = "total"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Total,
-								)
-							}
-
-							if timeseriesData.Minimum != nil {
-								metricLabels["aggregation"] = "minimum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Minimum,
-								)
-							}
-
-							if timeseriesData.Maximum != nil {
-								metricLabels["aggregation"] = "maximum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Maximum,
-								)
-							}
-
-							if timeseriesData.Average != nil {
-								metricLabels["aggregation"] = "average"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Average,
-								)
-							}
-
-							if timeseriesData.Count != nil {
-								metricLabels["aggregation"] = "count"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Count,
-								)
-							}
-						}
+						r.sendTimeseriesDataToChannel(channel, metricLabels, timeseries.Data)
 					}
 				}
 			}
@@ -50,7 +50,8 @@ func (r *AzureInsightMetricsResult) SendMetricToChannel(channel chan<- Prometheu
 						}
 
 						subscriptionName := ""
-						if subscription, err := r.prober.AzureClient.GetCachedSubscription(r.prober.ctx, azureResource.Subscription); err == nil && subscription != nil {
+						subscription, err := r.prober.AzureClient.GetCachedSubscription(r.prober.ctx, azureResource.Subscription)
+						if err == nil && subscription != nil {
 							subscriptionName = to.String(subscription.DisplayName)
 						}
 
@@ -62,13 +63,20 @@ func (r *AzureInsightMetricsResult) SendMetricToChannel(channel chan<- Prometheu
 							"resourceName":     azureResource.ResourceName,
 							"metric":           to.String(metric.Name.Value),
 							"unit":             metricUnit,
-							"interval":         to.String(r.prober.settings.Interval),
-							"timespan":         r.prober.settings.Timespan,
+							"interval":         to.String(r.request.interval),
+							"timespan":         r.request.timespan,
 							"aggregation":      "",
 						}
 
 						// add resource tags as labels
-						metricLabels = r.prober.AzureResourceTagManager.AddResourceTagsToPrometheusLabels(r.prober.ctx, metricLabels, resourceId)
+						metricLabels = r.prober.addResourceTagsToLabels(metricLabels, r.target)
+						r.prober.addManagementGroupLabel(metricLabels, azureResource.Subscription)
+						r.prober.addSubscriptionTagLabels(metricLabels, subscription)
+
+						// add resourcegraph columns as labels
+						for labelName, labelValue := range r.target.Labels {
+							metricLabels[labelName] = labelValue
+						}
 
 						if len(dimensions) == 1 {
 							// we have only one dimension
@@ -86,47 +94,7 @@ func (r *AzureInsightMetricsResult) SendMetricToChannel(channel chan<- Prometheu
 							}
 						}
 
-						for _, timeseriesData := range timeseries.Data {
-							if timeseriesData.Total != nil {
-								metricLabels["aggregation"] = "total"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Total,
-								)
-							}
-
-							if timeseriesData.Minimum != nil {
-								metricLabels["aggregation"] = "minimum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Minimum,
-								)
-							}
-
-							if timeseriesData.Maximum != nil {
-								metricLabels["aggregation"] = "maximum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Maximum,
-								)
-							}
-
-							if timeseriesData.Average != nil {
-								metricLabels["aggregation"] = "average"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Average,
-								)
-							}
-
-							if timeseriesData.Count != nil {
-								metricLabels["aggregation"] = "count"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Count,
-								)
-							}
-						}
+						r.sendTimeseriesDataToChannel(channel, metricLabels, timeseries.Data)
 					}
 				}
 			}
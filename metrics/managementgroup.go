@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	ManagementGroupLabelName = "managementGroup"
+
+	managementGroupSubscriptionsQuery = `ResourceContainers
+| where type =~ "microsoft.resources/subscriptions"
+| where properties.state =~ "Enabled"
+| project subscriptionId`
+)
+
+// resolveManagementGroups adds all (enabled) subscriptions below the requested management groups to the subscription list
+func (p *MetricProber) resolveManagementGroups() error {
+	if len(p.settings.ManagementGroups) == 0 {
+		return nil
+	}
+
+	managementGroups := map[string]string{}
+	for _, managementGroup := range p.settings.ManagementGroups {
+		subscriptionList, err := p.ServiceDiscovery.fetchManagementGroupSubscriptions(managementGroup)
+		if err != nil {
+			return fmt.Errorf(`unable to resolve subscriptions of managementGroup "%v": %w`, managementGroup, err)
+		}
+
+		for _, subscriptionId := range subscriptionList {
+			subscriptionId = strings.ToLower(subscriptionId)
+
+			// first management group wins if subscription is below multiple requested management groups
+			if _, exists := managementGroups[subscriptionId]; !exists {
+				managementGroups[subscriptionId] = managementGroup
+			}
+		}
+	}
+
+	// add subscriptions which are not already requested by parameter subscription
+	subscriptions := map[string]bool{}
+	for _, subscriptionId := range p.settings.Subscriptions {
+		subscriptions[strings.ToLower(subscriptionId)] = true
+	}
+
+	for subscriptionId := range managementGroups {
+		if !subscriptions[subscriptionId] {
+			p.settings.Subscriptions = append(p.settings.Subscriptions, subscriptionId)
+		}
+	}
+
+	p.managementGroups = managementGroups
+	p.logger.Debug(fmt.Sprintf("found %v subscriptions in management groups", len(managementGroups)), slog.Any("managementGroups", p.settings.ManagementGroups))
+
+	return nil
+}
+
+// managementGroupLabel returns the requested management group of a subscription (empty if not resolved by management group)
+func (p *MetricProber) managementGroupLabel(subscriptionId string) string {
+	return p.managementGroups[strings.ToLower(subscriptionId)]
+}
+
+// addManagementGroupLabel adds the managementGroup label (only if management groups are requested)
+func (p *MetricProber) addManagementGroupLabel(labels map[string]string, subscriptionId string) {
+	if len(p.settings.ManagementGroups) > 0 {
+		labels[ManagementGroupLabelName] = p.managementGroupLabel(subscriptionId)
+	}
+}
+
+func (sd *AzureServiceDiscovery) fetchManagementGroupSubscriptions(managementGroup string) (subscriptionList []string, err error) {
+	cache := sd.prober.serviceDiscoveryCache.cache
+	cacheKey := "managementgroup:" + strings.ToLower(managementGroup)
+
+	if cache != nil {
+		if cacheData, ok := cache.Get(cacheKey); ok {
+			if err := json.Unmarshal(cacheData, &subscriptionList); err == nil {
+				sd.prober.logger.Debug("using managementGroup subscriptions from cache", slog.String("managementGroup", managementGroup))
+				return subscriptionList, nil
+			}
+		}
+	}
+
+	client, err := armresourcegraph.NewClient(sd.prober.AzureClient.GetCred(), sd.prober.AzureClient.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	queryFormat := armresourcegraph.ResultFormatObjectArray
+	queryTop := int32(ResourceGraphQueryTop)
+	queryRequest := armresourcegraph.QueryRequest{
+		Query: to.StringPtr(managementGroupSubscriptionsQuery),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: &queryFormat,
+			Top:          &queryTop,
+		},
+		ManagementGroups: []*string{to.StringPtr(managementGroup)},
+	}
+
+	for {
+		result, err := client.Resources(sd.prober.ctx, queryRequest, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resultList, ok := result.Data.([]interface{}); ok {
+			for _, v := range resultList {
+				if resultRow, ok := v.(map[string]interface{}); ok {
+					if subscriptionId := sd.resourceRowToString(resultRow["subscriptionId"]); subscriptionId != "" {
+						subscriptionList = append(subscriptionList, subscriptionId)
+					}
+				}
+			}
+		}
+
+		if result.SkipToken == nil {
+			break
+		}
+		queryRequest.Options.SkipToken = result.SkipToken
+	}
+
+	// store to cache (if enabled)
+	if cache != nil {
+		if cacheData, err := json.Marshal(subscriptionList); err == nil {
+			if err := cache.Set(cacheKey, cacheData, *sd.prober.serviceDiscoveryCache.cacheDuration); err != nil {
+				sd.prober.logger.Warn("unable to save managementGroup subscriptions to cache", slog.Any("error", err.Error()))
+			}
+		}
+	}
+
+	return subscriptionList, nil
+}
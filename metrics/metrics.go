@@ -1,6 +1,11 @@
 package metrics
 
 import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -15,9 +20,35 @@ type (
 	}
 
 	MetricRow struct {
-		Labels prometheus.Labels
-		Value  float64
+		Labels    prometheus.Labels
+		Value     float64
+		Timestamp *time.Time
+
+		// unit of the metric (only set if the metric name ends with the unit, OpenMetrics UNIT)
+		Unit string `json:",omitempty"`
+
+		// unit of the value as reported by Azure Monitor (converted with normalizeUnits), independent of the metric name
+		AzureUnit string `json:",omitempty"`
+
+		// value is cumulative (counter)
+		Counter bool `json:",omitempty"`
+
+		// start of accumulation of cumulative values (counter)
+		StartTimestamp *time.Time `json:",omitempty"`
 	}
+
+	// metricRowCollector publishes metric rows with timestamps (multiple rows per label set are possible)
+	// or with value type (eg. counter)
+	metricRowCollector struct {
+		desc       *prometheus.Desc
+		labelNames []string
+		rows       []MetricRow
+		valueType  prometheus.ValueType
+	}
+
+	// JsonFloat is a float64 which serializes non-finite values (NaN, +Inf, -Inf) as JSON string
+	// (encoding/json fails on them)
+	JsonFloat float64
 )
 
 func NewMetricList() *MetricList {
@@ -73,3 +104,172 @@ func (l *MetricList) GetMetricLabelNames(name string) []string {
 
 	return list
 }
+
+// GetProbeTargetSubscriptions returns the subscription of every probe target (one entry per target, see azurerm_probe_target_success)
+func (l *MetricList) GetProbeTargetSubscriptions() (list []string) {
+	for _, row := range l.GetMetricList(ProbeTargetSuccessMetricName) {
+		list = append(list, row.Labels["subscriptionID"])
+	}
+	return
+}
+
+// GetProbeTargetErrors returns the number of failed probe targets (azurerm_probe_target_success = 0)
+func (l *MetricList) GetProbeTargetErrors() (count int) {
+	for _, row := range l.GetMetricList(ProbeTargetSuccessMetricName) {
+		if row.Value == 0 {
+			count++
+		}
+	}
+	return
+}
+
+// GetMetricUnit returns the unit of a metric, empty if the unit is not set or differs between metric rows
+func (l *MetricList) GetMetricUnit(name string) (unit string) {
+	for i, row := range l.List[name] {
+		if i == 0 {
+			unit = row.Unit
+		} else if row.Unit != unit {
+			return ""
+		}
+	}
+	return
+}
+
+// GetMetricUnits returns the units of all metrics (only metrics with unit)
+func (l *MetricList) GetMetricUnits() map[string]string {
+	units := map[string]string{}
+	for _, name := range l.GetMetricNames() {
+		if unit := l.GetMetricUnit(name); unit != "" {
+			units[name] = unit
+		}
+	}
+	return units
+}
+
+// GetMetricValueType returns counter if all metric rows are cumulative, otherwise gauge
+func (l *MetricList) GetMetricValueType(name string) prometheus.ValueType {
+	for _, row := range l.List[name] {
+		if !row.Counter {
+			return prometheus.GaugeValue
+		}
+	}
+	return prometheus.CounterValue
+}
+
+// Publish creates prometheus metrics for all metric rows and registers them in registry
+func (l *MetricList) Publish(registry prometheus.Registerer) {
+	for _, metricName := range l.GetMetricNames() {
+		valueType := l.GetMetricValueType(metricName)
+		if valueType != prometheus.GaugeValue || l.hasTimestamps(metricName) {
+			labelNames := l.GetMetricLabelNames(metricName)
+			registry.MustRegister(&metricRowCollector{
+				desc:       prometheus.NewDesc(metricName, l.GetMetricHelp(metricName), labelNames, nil),
+				labelNames: labelNames,
+				rows:       l.GetMetricList(metricName),
+				valueType:  valueType,
+			})
+			continue
+		}
+
+		gauge := prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName,
+				Help: l.GetMetricHelp(metricName),
+			},
+			l.GetMetricLabelNames(metricName),
+		)
+		registry.MustRegister(gauge)
+
+		for _, row := range l.GetMetricList(metricName) {
+			gauge.With(row.Labels).Set(row.Value)
+		}
+	}
+}
+
+// MarshalJSON serializes the row, non-finite values are serialized as string
+func (r MetricRow) MarshalJSON() ([]byte, error) {
+	type metricRow MetricRow
+	return json.Marshal(struct {
+		metricRow
+		Value JsonFloat
+	}{
+		metricRow: metricRow(r),
+		Value:     JsonFloat(r.Value),
+	})
+}
+
+// UnmarshalJSON parses a row serialized by MarshalJSON
+func (r *MetricRow) UnmarshalJSON(data []byte) error {
+	type metricRow MetricRow
+	row := struct {
+		*metricRow
+		Value JsonFloat
+	}{
+		metricRow: (*metricRow)(r),
+	}
+
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	r.Value = float64(row.Value)
+	return nil
+}
+
+func (f JsonFloat) MarshalJSON() ([]byte, error) {
+	value := float64(f)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return json.Marshal(strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return json.Marshal(value)
+}
+
+func (f *JsonFloat) UnmarshalJSON(data []byte) error {
+	var value float64
+
+	// non-finite values are serialized as string
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+
+		parsedValue, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return err
+		}
+		value = parsedValue
+	} else if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*f = JsonFloat(value)
+	return nil
+}
+
+func (l *MetricList) hasTimestamps(name string) bool {
+	for _, row := range l.List[name] {
+		if row.Timestamp != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *metricRowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *metricRowCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, row := range c.rows {
+		labelValues := make([]string, len(c.labelNames))
+		for i, labelName := range c.labelNames {
+			labelValues[i] = row.Labels[labelName]
+		}
+
+		metric := prometheus.MustNewConstMetric(c.desc, c.valueType, row.Value, labelValues...)
+		if row.Timestamp != nil {
+			metric = prometheus.NewMetricWithTimestamp(*row.Timestamp, metric)
+		}
+		ch <- metric
+	}
+}
@@ -1,9 +1,14 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 )
 
 func paramsGetWithDefault(params url.Values, name, defaultValue string) (value string) {
@@ -38,3 +43,20 @@ func stringToStringList(v string, sep string) (list []string) {
 	}
 	return
 }
+
+// azureErrorCode returns the error code of an Azure ResponseError (or a generic error code)
+func azureErrorCode(err error) string {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		if responseErr.ErrorCode != "" {
+			return responseErr.ErrorCode
+		}
+		return strconv.Itoa(responseErr.StatusCode)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "Timeout"
+	}
+
+	return "Unknown"
+}
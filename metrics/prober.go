@@ -2,25 +2,39 @@ package metrics
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
-	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/remeh/sizedwaitgroup"
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/utils/to"
 
+	"github.com/webdevops/azure-metrics-exporter/cache"
 	"github.com/webdevops/azure-metrics-exporter/config"
 )
 
 const (
 	AzureMetricApiMaxMetricNumber = 20
+
+	// interval (timegrain) used by Azure Monitor if no interval is requested
+	AzureMetricIntervalDefault = 1 * time.Minute
+
+	MetricsCacheStatusHit   = "hit"
+	MetricsCacheStatusMiss  = "miss"
+	MetricsCacheStatusStale = "stale"
+
+	MetricsCacheLockTtlDefault = 5 * time.Minute
+
+	ProbeTargetSuccessMetricName  = "azurerm_probe_target_success"
+	ProbeTargetDurationMetricName = "azurerm_probe_target_duration_seconds"
 )
 
 type (
@@ -41,18 +55,44 @@ type (
 		logger *slog.Logger
 
 		metricsCache struct {
-			cache         *cache.Cache
+			cache         cache.Backend
 			cacheKey      *string
 			cacheDuration *time.Duration
+
+			// result of FetchFromCache
+			status string
+			age    time.Duration
 		}
 
 		serviceDiscoveryCache struct {
-			cache         *cache.Cache
+			cache         cache.Backend
 			cacheDuration *time.Duration
 		}
 
+		// counter for resources excluded from service discovery (label reason)
+		serviceDiscoveryExcluded *prometheus.CounterVec
+
+		// subscription list is expanded (subscription=* and management groups)
+		subscriptionsResolved bool
+
+		// subscriptions resolved by management groups (subscriptionId -> managementGroup)
+		managementGroups map[string]string
+
+		coalescing struct {
+			coalescer *ProbeCoalescer
+			key       string
+		}
+
 		targets map[string][]MetricProbeTarget
 
+		// failed service discoveries (subscriptionId -> error), published as failed probe targets
+		discoveryErrors map[string]error
+
+		metricDefinitions struct {
+			lock    sync.Mutex
+			entries map[string]*metricDefinitionsEntry
+		}
+
 		metricList *MetricList
 
 		prometheus struct {
@@ -60,15 +100,30 @@ type (
 		}
 
 		callbackSubscriptionFishish func(subscriptionId string)
+		callbackTargetFinish        func(subscriptionId string, err error)
+		callbackMetricListCollected []func(metricList *MetricList)
 
 		ServiceDiscovery AzureServiceDiscovery
 	}
 
+	// MetricsCacheEntry is a cached metric list, expired entries are served as stale during the grace period
+	MetricsCacheEntry struct {
+		MetricList *MetricList
+		CreatedAt  time.Time
+		ExpiresAt  time.Time
+	}
+
 	MetricProbeTarget struct {
 		ResourceId   string
+		Location     string
 		Metrics      []string
 		Aggregations []string
-		Tags         map[string]string
+
+		// resource tags from service discovery (nil if not discovered, eg. static targets)
+		Tags map[string]string
+
+		// additional labels from service discovery (resourcegraph columns)
+		Labels map[string]string
 	}
 )
 
@@ -86,6 +141,8 @@ func NewMetricProber(ctx context.Context, logger *slog.Logger, w http.ResponseWr
 
 func (p *MetricProber) Init() {
 	p.targets = map[string][]MetricProbeTarget{}
+	p.discoveryErrors = map[string]error{}
+	p.metricDefinitions.entries = map[string]*metricDefinitionsEntry{}
 
 	p.metricList = NewMetricList()
 }
@@ -93,6 +150,16 @@ func (p *MetricProber) RegisterSubscriptionCollectFinishCallback(callback func(s
 	p.callbackSubscriptionFishish = callback
 }
 
+func (p *MetricProber) RegisterTargetCollectFinishCallback(callback func(subscriptionId string, err error)) {
+	p.callbackTargetFinish = callback
+}
+
+// RegisterMetricListCollectedCallback registers a callback which is called after metrics are collected from Azure
+// (not for metric lists served from cache or by coalesced probes), multiple callbacks can be registered
+func (p *MetricProber) RegisterMetricListCollectedCallback(callback func(metricList *MetricList)) {
+	p.callbackMetricListCollected = append(p.callbackMetricListCollected, callback)
+}
+
 func (p *MetricProber) SetUserAgent(value string) {
 	p.userAgent = value
 }
@@ -109,17 +176,34 @@ func (p *MetricProber) SetAzureResourceTagManager(client *armclient.ResourceTagM
 	p.AzureResourceTagManager = client
 }
 
-func (p *MetricProber) EnableMetricsCache(cache *cache.Cache, cacheKey string, cacheDuration *time.Duration) {
+// SetServiceDiscoveryExcludedCounter sets the counter for resources excluded by exclusion rules (label reason)
+func (p *MetricProber) SetServiceDiscoveryExcludedCounter(counter *prometheus.CounterVec) {
+	p.serviceDiscoveryExcluded = counter
+}
+
+func (p *MetricProber) countExcludedTarget(reason string) {
+	if p.serviceDiscoveryExcluded != nil {
+		p.serviceDiscoveryExcluded.With(prometheus.Labels{"reason": reason}).Inc()
+	}
+}
+
+func (p *MetricProber) EnableMetricsCache(cache cache.Backend, cacheKey string, cacheDuration *time.Duration) {
 	p.metricsCache.cache = cache
 	p.metricsCache.cacheKey = &cacheKey
 	p.metricsCache.cacheDuration = cacheDuration
 }
 
-func (p *MetricProber) EnableServiceDiscoveryCache(cache *cache.Cache, cacheDuration *time.Duration) {
+func (p *MetricProber) EnableServiceDiscoveryCache(cache cache.Backend, cacheDuration *time.Duration) {
 	p.serviceDiscoveryCache.cache = cache
 	p.serviceDiscoveryCache.cacheDuration = cacheDuration
 }
 
+// EnableRequestCoalescing enables sharing of one collection run for concurrent identical probes (same key)
+func (p *MetricProber) EnableRequestCoalescing(coalescer *ProbeCoalescer, key string) {
+	p.coalescing.coalescer = coalescer
+	p.coalescing.key = key
+}
+
 func (p *MetricProber) AddTarget(targets ...MetricProbeTarget) {
 	for _, target := range targets {
 		resourceInfo, err := armclient.ParseResourceId(target.ResourceId)
@@ -137,28 +221,134 @@ func (p *MetricProber) AddTarget(targets ...MetricProbeTarget) {
 	}
 }
 
+// addDiscoveryError records a failed service discovery of a subscription (published as failed probe target)
+func (p *MetricProber) addDiscoveryError(subscriptionId string, err error) {
+	p.logger.With(slog.String("subscriptionID", subscriptionId)).Error(err.Error())
+	p.discoveryErrors[subscriptionId] = err
+}
+
+// sendDiscoveryStatusToChannel sends the failed service discoveries as failed probe targets (resourceID of subscription)
+func (p *MetricProber) sendDiscoveryStatusToChannel(channel chan<- PrometheusMetricResult) {
+	for subscriptionId, err := range p.discoveryErrors {
+		p.sendTargetStatusToChannel(channel, "/subscriptions/"+subscriptionId, subscriptionId, "", 0, err)
+	}
+}
+
 func (p *MetricProber) FetchFromCache() bool {
 	if p.metricsCache.cache == nil {
 		return false
 	}
 
-	if val, ok := p.metricsCache.cache.Get(*p.metricsCache.cacheKey); ok {
-		p.metricList = val.(*MetricList)
-		p.publishMetricList()
-		return true
+	p.metricsCache.status = MetricsCacheStatusMiss
+	if cacheData, ok := p.metricsCache.cache.Get(*p.metricsCache.cacheKey); ok {
+		if p.loadMetricsCacheEntry(cacheData) {
+			p.publishMetricList()
+			return true
+		}
 	}
 
 	return false
 }
 
+// loadMetricsCacheEntry parses cached metrics and uses them as metric list
+func (p *MetricProber) loadMetricsCacheEntry(cacheData []byte) bool {
+	entry := MetricsCacheEntry{}
+	if err := json.Unmarshal(cacheData, &entry); err != nil || entry.MetricList == nil {
+		p.logger.Debug("unable to parse cached metrics")
+		return false
+	}
+
+	p.metricList = entry.MetricList
+	p.metricsCache.age = time.Since(entry.CreatedAt)
+	p.metricsCache.status = MetricsCacheStatusHit
+
+	// expired entry (inside grace period)
+	if time.Now().After(entry.ExpiresAt) {
+		p.metricsCache.status = MetricsCacheStatusStale
+		if p.response != nil {
+			p.response.Header().Add("X-metrics-stale", "true")
+		}
+	}
+
+	if p.response != nil {
+		p.response.Header().Add("X-metrics-cache-age", fmt.Sprintf("%.0f", p.metricsCache.age.Seconds()))
+	}
+
+	return true
+}
+
+// collectWithCacheLock runs collect and saves the result to cache, for shared cache backends only one
+// exporter instance collects the metrics and other instances wait for its cached result
+func (p *MetricProber) collectWithCacheLock(collect func() error) error {
+	if p.metricsCache.cache != nil {
+		cacheData, unlock := cache.LockOrWait(p.ctx, p.metricsCache.cache, *p.metricsCache.cacheKey, p.cacheLockTtl())
+		if unlock == nil && p.loadMetricsCacheEntry(cacheData) {
+			p.logger.Debug("using metrics collected by other exporter instance")
+			return nil
+		}
+
+		if unlock != nil {
+			defer unlock()
+		}
+	}
+
+	if err := collect(); err != nil {
+		return err
+	}
+	p.SaveToCache()
+	return nil
+}
+
+// cacheLockTtl returns the expiry of refresh locks (remaining probe time or default)
+func (p *MetricProber) cacheLockTtl() time.Duration {
+	if deadline, ok := p.ctx.Deadline(); ok {
+		if ttl := time.Until(deadline); ttl > 0 {
+			return ttl
+		}
+	}
+	return MetricsCacheLockTtlDefault
+}
+
+// MetricsCacheStatus returns the metrics cache status (hit, miss or stale; empty if cache is disabled) and age of cached entry
+func (p *MetricProber) MetricsCacheStatus() (string, time.Duration) {
+	return p.metricsCache.status, p.metricsCache.age
+}
+
+// MetricsCacheKey returns the metrics cache key (empty if cache is disabled)
+func (p *MetricProber) MetricsCacheKey() string {
+	if p.metricsCache.cacheKey == nil {
+		return ""
+	}
+	return *p.metricsCache.cacheKey
+}
+
 func (p *MetricProber) SaveToCache() {
 	if p.metricsCache.cache == nil {
 		return
 	}
 
 	if p.metricsCache.cacheDuration != nil {
-		_ = p.metricsCache.cache.Add(*p.metricsCache.cacheKey, p.metricList, *p.metricsCache.cacheDuration)
-		p.response.Header().Add("X-metrics-cached-until", time.Now().Add(*p.metricsCache.cacheDuration).Format(time.RFC3339))
+		entry := MetricsCacheEntry{
+			MetricList: p.metricList,
+			CreatedAt:  time.Now(),
+			ExpiresAt:  time.Now().Add(*p.metricsCache.cacheDuration),
+		}
+
+		cacheData, err := json.Marshal(entry)
+		if err != nil {
+			p.logger.Warn("unable to serialize metrics for cache", slog.Any("error", err.Error()))
+			return
+		}
+
+		// keep entry for grace period (stale-while-revalidate)
+		if err := p.metricsCache.cache.Set(*p.metricsCache.cacheKey, cacheData, *p.metricsCache.cacheDuration+p.Conf.Prober.CacheStaleGrace); err != nil {
+			p.logger.Warn("unable to save metrics to cache", slog.Any("error", err.Error()))
+			return
+		}
+
+		if p.response != nil {
+			p.response.Header().Add("X-metrics-cached-until", entry.ExpiresAt.Format(time.RFC3339))
+		}
 	}
 }
 
@@ -174,6 +364,78 @@ func (p *MetricProber) RunOnSubscriptionScope() {
 	p.publishMetricList()
 }
 
+// RunCoalesced runs service discovery (optional) and collection like Run, but concurrent identical probes
+// share one run; returns true if the result of another probe was used
+func (p *MetricProber) RunCoalesced(discovery func() error) (coalesced bool, err error) {
+	return p.runCoalesced(func() error {
+		if discovery != nil {
+			if err := discovery(); err != nil {
+				return err
+			}
+		}
+		p.collectMetricsFromTargets()
+		return nil
+	})
+}
+
+// RunOnSubscriptionScopeCoalesced runs like RunOnSubscriptionScope, but concurrent identical probes
+// share one run; returns true if the result of another probe was used
+func (p *MetricProber) RunOnSubscriptionScopeCoalesced() (coalesced bool, err error) {
+	return p.runCoalesced(func() error {
+		p.collectMetricsFromSubscriptions()
+		return nil
+	})
+}
+
+func (p *MetricProber) runCoalesced(collectFunc func() error) (coalesced bool, err error) {
+	collect := func() error {
+		if err := p.ResolveSubscriptions(); err != nil {
+			return err
+		}
+		return collectFunc()
+	}
+
+	if p.coalescing.coalescer == nil {
+		if err := p.collectWithCacheLock(collect); err != nil {
+			return false, err
+		}
+		p.publishMetricList()
+		return false, nil
+	}
+
+	metricList, coalesced, err := p.coalescing.coalescer.Do(p.ctx, p.coalescing.key, func() (*MetricList, error) {
+		if err := p.collectWithCacheLock(collect); err != nil {
+			return nil, err
+		}
+		// don't share incomplete results of a cancelled probe (waiting probes collect again)
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+		return p.metricList, nil
+	})
+	if err != nil {
+		return coalesced, err
+	}
+
+	p.metricList = metricList
+	p.publishMetricList()
+	return coalesced, nil
+}
+
+// Collect collects metrics from all targets without publishing them (eg. for background collection)
+func (p *MetricProber) Collect() {
+	p.collectMetricsFromTargets()
+}
+
+// CollectOnSubscriptionScope collects metrics on subscription scope without publishing them (eg. for background collection)
+func (p *MetricProber) CollectOnSubscriptionScope() {
+	p.collectMetricsFromSubscriptions()
+}
+
+func (p *MetricProber) GetMetricList() *MetricList {
+	return p.metricList
+}
+
 func (p *MetricProber) collectMetricsFromSubscriptions() {
 	metricsChannel := make(chan PrometheusMetricResult)
 
@@ -184,6 +446,10 @@ func (p *MetricProber) collectMetricsFromSubscriptions() {
 		regions, err := p.discoverResourceRegions()
 		if err != nil {
 			p.logger.Error("error getting subscription locations", slog.Any("error", err))
+			for _, subscriptionId := range p.settings.Subscriptions {
+				p.sendTargetStatusToChannel(metricsChannel, "/subscriptions/"+subscriptionId, subscriptionId, "", 0, err)
+			}
+			close(metricsChannel)
 			return
 		}
 
@@ -191,26 +457,30 @@ func (p *MetricProber) collectMetricsFromSubscriptions() {
 			subscriptionRegions := regions[*subscription.SubscriptionID]
 
 			for _, region := range subscriptionRegions {
+				regionStartTime := time.Now()
+				var regionErr error
+				subscriptionResourceId := "/subscriptions/" + *subscription.SubscriptionID
+
 				client, err := p.MetricsClient(*subscription.SubscriptionID)
 				if err != nil {
-					// FIXME: find a better way to report errors
 					p.logger.Error(err.Error())
-					return
+					p.sendTargetStatusToChannel(metricsChannel, subscriptionResourceId, *subscription.SubscriptionID, region, time.Since(regionStartTime), err)
+					continue
 				}
 
-				// request metrics in 20 metrics chunks (azure metric api limitation)
-				for i := 0; i < len(p.settings.Metrics); i += AzureMetricApiMaxMetricNumber {
-					end := i + AzureMetricApiMaxMetricNumber
-					if end > len(p.settings.Metrics) {
-						end = len(p.settings.Metrics)
-					}
-					metricList := p.settings.Metrics[i:end]
+				requestList, err := p.metricRequests(p.settings.Metrics, p.settings.Aggregations)
+				if err != nil {
+					p.logger.Error(err.Error())
+					p.sendTargetStatusToChannel(metricsChannel, subscriptionResourceId, *subscription.SubscriptionID, region, time.Since(regionStartTime), err)
+					continue
+				}
 
+				for _, request := range requestList {
 					resultType := armmonitor.MetricResultTypeData
 					opts := armmonitor.MetricsClientListAtSubscriptionScopeOptions{
-						Interval:            p.settings.Interval,
-						Timespan:            to.StringPtr(p.settings.Timespan),
-						Metricnames:         to.StringPtr(strings.Join(metricList, ",")),
+						Interval:            request.interval,
+						Timespan:            to.StringPtr(request.timespan),
+						Metricnames:         to.StringPtr(strings.Join(request.metrics, ",")),
 						Metricnamespace:     to.StringPtr(p.settings.ResourceType),
 						Top:                 p.settings.MetricTop,
 						AutoAdjustTimegrain: to.BoolPtr(true),
@@ -219,8 +489,8 @@ func (p *MetricProber) collectMetricsFromSubscriptions() {
 						Filter:              to.StringPtr(`Microsoft.ResourceId eq '*'`),
 					}
 
-					if len(p.settings.Aggregations) >= 1 {
-						opts.Aggregation = to.StringPtr(strings.Join(p.settings.Aggregations, ","))
+					if len(request.aggregations) >= 1 {
+						opts.Aggregation = to.StringPtr(strings.Join(request.aggregations, ","))
 					}
 
 					if len(p.settings.MetricFilter) >= 1 {
@@ -237,20 +507,23 @@ func (p *MetricProber) collectMetricsFromSubscriptions() {
 
 					response, err := client.ListAtSubscriptionScope(p.ctx, region, &opts)
 					if err != nil {
-						// FIXME: find a better way to report errors
-						p.logger.Error(err.Error())
-						return
+						logger.With(slog.String("region", region)).Error(err.Error())
+						regionErr = err
+						continue
 					}
 
 					result := AzureInsightSubscriptionMetricsResult{
 						AzureInsightBaseMetricsResult: AzureInsightBaseMetricsResult{
-							prober: p,
+							prober:  p,
+							request: &request,
 						},
 						subscription: subscription,
 						Result:       &response}
 					result.SendMetricToChannel(metricsChannel)
 				}
 
+				p.sendTargetStatusToChannel(metricsChannel, subscriptionResourceId, *subscription.SubscriptionID, region, time.Since(regionStartTime), regionErr)
+
 				if p.callbackSubscriptionFishish != nil {
 					p.callbackSubscriptionFishish(*subscription.SubscriptionID)
 				}
@@ -264,14 +537,7 @@ func (p *MetricProber) collectMetricsFromSubscriptions() {
 		close(metricsChannel)
 	}()
 
-	for result := range metricsChannel {
-		metric := MetricRow{
-			Labels: result.Labels,
-			Value:  result.Value,
-		}
-		p.metricList.Add(result.Name, metric)
-		p.metricList.SetMetricHelp(result.Name, result.Help)
-	}
+	p.receiveMetricsFromChannel(metricsChannel)
 }
 
 func (p *MetricProber) discoverResourceRegions() (map[string][]string, error) {
@@ -310,11 +576,18 @@ func (p *MetricProber) discoverResourceRegions() (map[string][]string, error) {
 }
 
 func (p *MetricProber) collectMetricsFromTargets() {
+	if p.settings.Batch {
+		p.collectMetricsFromTargetsBatch()
+		return
+	}
+
 	metricsChannel := make(chan PrometheusMetricResult)
 
 	wgSubscription := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscription)
 
 	go func() {
+		p.sendDiscoveryStatusToChannel(metricsChannel)
+
 		for subscriptionId, resourceList := range p.targets {
 			wgSubscription.Add()
 			go func(subscriptionId string, targetList []MetricProbeTarget) {
@@ -323,8 +596,10 @@ func (p *MetricProber) collectMetricsFromTargets() {
 				wgSubscriptionResource := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscriptionResource)
 				client, err := p.MetricsClient(subscriptionId)
 				if err != nil {
-					// FIXME: find a better way to report errors
 					p.logger.Error(err.Error())
+					for _, target := range targetList {
+						p.sendTargetStatusToChannel(metricsChannel, target.ResourceId, subscriptionId, "", 0, err)
+					}
 					return
 				}
 
@@ -332,21 +607,25 @@ func (p *MetricProber) collectMetricsFromTargets() {
 					wgSubscriptionResource.Add()
 					go func(target MetricProbeTarget) {
 						defer wgSubscriptionResource.Done()
+						targetStartTime := time.Now()
+						var targetErr error
 
-						// request metrics in 20 metrics chunks (azure metric api limitation)
-						for i := 0; i < len(target.Metrics); i += AzureMetricApiMaxMetricNumber {
-							end := i + AzureMetricApiMaxMetricNumber
-							if end > len(target.Metrics) {
-								end = len(target.Metrics)
-							}
-							metricList := target.Metrics[i:end]
+						requestList, err := p.targetMetricRequests(subscriptionId, target)
+						if err != nil {
+							p.logger.With(slog.String("resourceID", target.ResourceId)).Warn(err.Error())
+							targetErr = err
+						}
 
-							if result, err := p.FetchMetricsFromTarget(client, target, metricList, target.Aggregations); err == nil {
+						for _, request := range requestList {
+							if result, err := p.FetchMetricsFromTarget(client, target, request); err == nil {
 								result.SendMetricToChannel(metricsChannel)
 							} else {
 								p.logger.With(slog.String("resourceID", target.ResourceId)).Warn(err.Error())
+								targetErr = err
 							}
 						}
+
+						p.sendTargetStatusToChannel(metricsChannel, target.ResourceId, subscriptionId, "", time.Since(targetStartTime), targetErr)
 					}(target)
 				}
 				wgSubscriptionResource.Wait()
@@ -360,14 +639,66 @@ func (p *MetricProber) collectMetricsFromTargets() {
 		close(metricsChannel)
 	}()
 
+	p.receiveMetricsFromChannel(metricsChannel)
+}
+
+// receiveMetricsFromChannel adds all metric results to the metric list (until channel is closed)
+func (p *MetricProber) receiveMetricsFromChannel(metricsChannel <-chan PrometheusMetricResult) {
 	for result := range metricsChannel {
 		metric := MetricRow{
-			Labels: result.Labels,
-			Value:  result.Value,
+			Labels:    result.Labels,
+			Value:     result.Value,
+			Timestamp: result.Timestamp,
+			Unit:      result.Unit,
+			AzureUnit: result.AzureUnit,
+			Counter:   result.Counter,
+
+			StartTimestamp: result.StartTimestamp,
 		}
 		p.metricList.Add(result.Name, metric)
 		p.metricList.SetMetricHelp(result.Name, result.Help)
 	}
+
+	for _, callback := range p.callbackMetricListCollected {
+		callback(p.metricList)
+	}
+}
+
+// sendTargetStatusToChannel sends success and duration of one probe target (resource or subscription and region)
+func (p *MetricProber) sendTargetStatusToChannel(channel chan<- PrometheusMetricResult, resourceId, subscriptionId, region string, duration time.Duration, err error) {
+	successValue := 1.0
+	errorCode := ""
+	if err != nil {
+		successValue = 0
+		errorCode = azureErrorCode(err)
+	}
+
+	channel <- PrometheusMetricResult{
+		Name: ProbeTargetSuccessMetricName,
+		Help: "Azure metrics probe target success (1 = success, 0 = error)",
+		Labels: prometheus.Labels{
+			"resourceID":     strings.ToLower(resourceId),
+			"subscriptionID": subscriptionId,
+			"region":         region,
+			"errorCode":      errorCode,
+		},
+		Value: successValue,
+	}
+
+	channel <- PrometheusMetricResult{
+		Name: ProbeTargetDurationMetricName,
+		Help: "Azure metrics probe target duration",
+		Labels: prometheus.Labels{
+			"resourceID":     strings.ToLower(resourceId),
+			"subscriptionID": subscriptionId,
+			"region":         region,
+		},
+		Value: duration.Seconds(),
+	}
+
+	if p.callbackTargetFinish != nil {
+		p.callbackTargetFinish(subscriptionId, err)
+	}
 }
 
 func (p *MetricProber) publishMetricList() {
@@ -375,19 +706,5 @@ func (p *MetricProber) publishMetricList() {
 		return
 	}
 
-	// create prometheus metrics and set rows
-	for _, metricName := range p.metricList.GetMetricNames() {
-		gauge := prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: metricName,
-				Help: p.metricList.GetMetricHelp(metricName),
-			},
-			p.metricList.GetMetricLabelNames(metricName),
-		)
-		p.prometheus.registry.MustRegister(gauge)
-
-		for _, row := range p.metricList.GetMetricList(metricName) {
-			gauge.With(row.Labels).Set(row.Value)
-		}
-	}
+	p.metricList.Publish(p.prometheus.registry)
 }
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/cache"
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+func newTestProber(t *testing.T, backend cache.Backend) *MetricProber {
+	t.Helper()
+
+	cacheDuration := 1 * time.Minute
+	prober := NewMetricProber(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), nil, &RequestMetricSettings{}, config.Opts{})
+	prober.SetPrometheusRegistry(prometheus.NewRegistry())
+	prober.EnableMetricsCache(backend, "test", &cacheDuration)
+	return prober
+}
+
+func TestMetricsCacheFileBackendRoundTrip(t *testing.T) {
+	path := t.TempDir()
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	backend, err := cache.NewFileBackend(path, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prober := newTestProber(t, backend)
+	prober.metricList.SetMetricHelp("azurerm_test", "test metric")
+	prober.metricList.Add(
+		"azurerm_test",
+		MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: 1.5, Timestamp: &timestamp, AzureUnit: "Percent"},
+		MetricRow{Labels: prometheus.Labels{"resourceID": "b"}, Value: math.NaN(), Counter: true},
+		MetricRow{Labels: prometheus.Labels{"resourceID": "c"}, Value: math.Inf(1)},
+		MetricRow{Labels: prometheus.Labels{"resourceID": "d"}, Value: math.Inf(-1)},
+	)
+	prober.SaveToCache()
+
+	// restore at startup
+	restoredBackend, err := cache.NewFileBackend(path, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestProber(t, restoredBackend)
+	if !restored.FetchFromCache() {
+		t.Fatal("expected metrics to be restored from file cache")
+	}
+
+	if status, _ := restored.MetricsCacheStatus(); status != MetricsCacheStatusHit {
+		t.Errorf("expected cache status %v, got %v", MetricsCacheStatusHit, status)
+	}
+
+	if help := restored.GetMetricList().GetMetricHelp("azurerm_test"); help != "test metric" {
+		t.Errorf(`expected help "test metric", got %q`, help)
+	}
+
+	rows := restored.GetMetricList().GetMetricList("azurerm_test")
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %v", len(rows))
+	}
+
+	if rows[0].Value != 1.5 || rows[0].Timestamp == nil || !rows[0].Timestamp.Equal(timestamp) || rows[0].AzureUnit != "Percent" || rows[0].Labels["resourceID"] != "a" {
+		t.Errorf("unexpected restored row: %+v", rows[0])
+	}
+	if !math.IsNaN(rows[1].Value) || !rows[1].Counter {
+		t.Errorf("expected NaN counter row, got %+v", rows[1])
+	}
+	if !math.IsInf(rows[2].Value, 1) {
+		t.Errorf("expected +Inf row, got %+v", rows[2])
+	}
+	if !math.IsInf(rows[3].Value, -1) {
+		t.Errorf("expected -Inf row, got %+v", rows[3])
+	}
+}
+
+func TestServiceDiscoveryErrorTargetStatus(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		prober := NewMetricProber(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), nil, &RequestMetricSettings{Batch: batch}, config.Opts{})
+
+		var callbackErrors int
+		prober.RegisterTargetCollectFinishCallback(func(subscriptionId string, err error) {
+			if err != nil {
+				callbackErrors++
+			}
+		})
+
+		prober.addDiscoveryError("sub-a", fmt.Errorf("servicediscovery failed: %w", &azcore.ResponseError{ErrorCode: "AuthorizationFailed", StatusCode: 403}))
+		prober.Collect()
+
+		rows := prober.GetMetricList().GetMetricList(ProbeTargetSuccessMetricName)
+		if len(rows) != 1 {
+			t.Fatalf("batch=%v: expected 1 target status, got %v", batch, len(rows))
+		}
+
+		row := rows[0]
+		if row.Value != 0 || row.Labels["errorCode"] != "AuthorizationFailed" || row.Labels["subscriptionID"] != "sub-a" || row.Labels["resourceID"] != "/subscriptions/sub-a" {
+			t.Errorf("batch=%v: unexpected target status: %+v", batch, row)
+		}
+
+		if errors := prober.GetMetricList().GetProbeTargetErrors(); errors != 1 {
+			t.Errorf("batch=%v: expected 1 failed target, got %v", batch, errors)
+		}
+
+		if callbackErrors != 1 {
+			t.Errorf("batch=%v: expected 1 failed target callback, got %v", batch, callbackErrors)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	iso8601 "github.com/channelmeter/iso8601duration"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+type (
+	// metricRequest is a list of metrics which are requested together (same request settings)
+	metricRequest struct {
+		metrics      []string
+		aggregations []string
+		interval     *string
+		timespan     string
+	}
+
+	// metricSpec is a metric with optional per metric settings (syntax: name[:aggregation[:interval[:timespan]]])
+	metricSpec struct {
+		name        string
+		aggregation string
+		interval    string
+		timespan    string
+	}
+)
+
+// parseMetricSpec parses a metric with optional per metric settings,
+// an interval without timespan is also used as timespan (eg. "name::PT1H" requests one PT1H datapoint, not the request timespan)
+func parseMetricSpec(value string) (spec metricSpec, err error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 4 {
+		return spec, fmt.Errorf(`metric "%v" is invalid, expected syntax "name[:aggregation[:interval[:timespan]]]"`, value)
+	}
+
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+
+	spec = metricSpec{
+		name:        strings.TrimSpace(parts[0]),
+		aggregation: strings.TrimSpace(parts[1]),
+		interval:    strings.TrimSpace(parts[2]),
+		timespan:    strings.TrimSpace(parts[3]),
+	}
+
+	if spec.name == "" {
+		return spec, fmt.Errorf(`metric "%v" is invalid, metric name is empty`, value)
+	}
+
+	if spec.aggregation != "" && !isSupportedAggregation(spec.aggregation) {
+		return spec, fmt.Errorf(`metric "%v" has unsupported aggregation "%v"`, value, spec.aggregation)
+	}
+
+	if spec.interval != "" {
+		if _, err := iso8601.FromString(spec.interval); err != nil {
+			return spec, fmt.Errorf(`metric "%v" has invalid interval "%v": %w`, value, spec.interval, err)
+		}
+
+		if spec.timespan == "" {
+			spec.timespan = spec.interval
+		}
+	}
+
+	return spec, nil
+}
+
+// isSupportedAggregation returns true if aggregation is an Azure Monitor aggregation type (case-insensitive, except None)
+func isSupportedAggregation(aggregation string) bool {
+	for _, aggregationType := range armmonitor.PossibleAggregationTypeValues() {
+		if aggregationType != armmonitor.AggregationTypeNone && strings.EqualFold(aggregation, string(aggregationType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// timegrain returns the interval of the requested datapoints (default interval of Azure Monitor if not set)
+func (r *metricRequest) timegrain() time.Duration {
+	if r.interval != nil {
+		if duration, err := iso8601.FromString(*r.interval); err == nil && duration.ToDuration() > 0 {
+			return duration.ToDuration()
+		}
+	}
+	return AzureMetricIntervalDefault
+}
+
+func isMetricWildcard(metrics []string) bool {
+	for _, metric := range metrics {
+		if metric == MetricWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *MetricProber) isMetricExcluded(metric string) bool {
+	for _, excludedMetric := range p.settings.MetricExclude {
+		if strings.EqualFold(metric, excludedMetric) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricRequests groups metrics with identical settings (per metric settings or request settings as default)
+// into requests of AzureMetricApiMaxMetricNumber metrics
+func (p *MetricProber) metricRequests(metrics, aggregations []string) ([]metricRequest, error) {
+	requestGroups := map[string]*metricRequest{}
+	requestGroupOrder := []string{}
+
+	for _, metric := range metrics {
+		spec, err := parseMetricSpec(metric)
+		if err != nil {
+			return nil, err
+		}
+
+		request := metricRequest{
+			aggregations: aggregations,
+			interval:     p.settings.Interval,
+			timespan:     p.settings.Timespan,
+		}
+
+		if spec.aggregation != "" {
+			request.aggregations = []string{spec.aggregation}
+		}
+
+		if spec.interval != "" {
+			interval := spec.interval
+			request.interval = &interval
+		}
+
+		if spec.timespan != "" {
+			request.timespan = spec.timespan
+		}
+
+		groupKey := strings.ToLower(strings.Join(
+			[]string{
+				strings.Join(request.aggregations, ","),
+				to.String(request.interval),
+				request.timespan,
+			},
+			"|",
+		))
+
+		if _, exists := requestGroups[groupKey]; !exists {
+			requestGroups[groupKey] = &request
+			requestGroupOrder = append(requestGroupOrder, groupKey)
+		}
+		requestGroups[groupKey].metrics = append(requestGroups[groupKey].metrics, spec.name)
+	}
+
+	requestList := []metricRequest{}
+	for _, groupKey := range requestGroupOrder {
+		requestList = append(requestList, chunkMetricRequest(*requestGroups[groupKey])...)
+	}
+
+	return requestList, nil
+}
+
+// targetMetricRequests returns the metric requests of a target,
+// metric wildcard is expanded to all metrics supported by the resource type
+func (p *MetricProber) targetMetricRequests(subscriptionId string, target MetricProbeTarget) ([]metricRequest, error) {
+	if !isMetricWildcard(target.Metrics) {
+		return p.metricRequests(target.Metrics, target.Aggregations)
+	}
+
+	definitionList, err := p.fetchCachedMetricDefinitions(subscriptionId, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// use primary aggregation of each metric if not set by request
+	metrics := []string{}
+	for _, definition := range definitionList {
+		if p.isMetricExcluded(definition.Name) {
+			continue
+		}
+
+		metric := definition.Name
+		if len(target.Aggregations) == 0 && definition.PrimaryAggregation != "" {
+			metric = metric + ":" + definition.PrimaryAggregation
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return p.metricRequests(metrics, target.Aggregations)
+}
+
+// chunkMetricRequest splits a metric request into chunks of 20 metrics (azure metric api limitation)
+func chunkMetricRequest(request metricRequest) (list []metricRequest) {
+	for i := 0; i < len(request.metrics); i += AzureMetricApiMaxMetricNumber {
+		end := i + AzureMetricApiMaxMetricNumber
+		if end > len(request.metrics) {
+			end = len(request.metrics)
+		}
+
+		chunk := request
+		chunk.metrics = request.metrics[i:end]
+		list = append(list, chunk)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetricSpec(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected metricSpec
+		error    string
+	}{
+		{value: "Percentage CPU", expected: metricSpec{name: "Percentage CPU"}},
+		{value: " requests : total ", expected: metricSpec{name: "requests", aggregation: "total"}},
+		{value: "requests:Average", expected: metricSpec{name: "requests", aggregation: "Average"}},
+		{value: "requests:count:PT5M:PT1H", expected: metricSpec{name: "requests", aggregation: "count", interval: "PT5M", timespan: "PT1H"}},
+		{value: "requests::PT1H", expected: metricSpec{name: "requests", interval: "PT1H", timespan: "PT1H"}},
+		{value: "requests:::PT1H", expected: metricSpec{name: "requests", timespan: "PT1H"}},
+		{value: "requests:maximum::PT1H", expected: metricSpec{name: "requests", aggregation: "maximum", timespan: "PT1H"}},
+		{value: "", error: "metric name is empty"},
+		{value: ":total", error: "metric name is empty"},
+		{value: "requests:total:PT1M:PT1H:x", error: "expected syntax"},
+		{value: "requests:sum", error: `unsupported aggregation "sum"`},
+		{value: "requests:none", error: `unsupported aggregation "none"`},
+		{value: "requests:total:1m", error: `invalid interval "1m"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			spec, err := parseMetricSpec(tc.value)
+			if tc.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.error) {
+					t.Fatalf("expected error %q, got %v", tc.error, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if spec != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, spec)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+// resolveResourceGroups returns the requested resource groups of a subscription, glob patterns (eg. team-*)
+// are matched against the resource groups of the subscription
+func (sd *AzureServiceDiscovery) resolveResourceGroups(subscriptionId string) (resourceGroupList []string, err error) {
+	var resourceGroupNames []string
+	resourceGroups := map[string]bool{}
+
+	addResourceGroup := func(resourceGroup string) {
+		if !resourceGroups[strings.ToLower(resourceGroup)] {
+			resourceGroups[strings.ToLower(resourceGroup)] = true
+			resourceGroupList = append(resourceGroupList, resourceGroup)
+		}
+	}
+
+	for _, pattern := range sd.prober.settings.ResourceGroups {
+		if !isGlobPattern(pattern) {
+			addResourceGroup(pattern)
+			continue
+		}
+
+		// lazy fetch of resource groups, only needed for glob patterns
+		if resourceGroupNames == nil {
+			resourceGroupNames, err = sd.fetchResourceGroupList(subscriptionId)
+			if err != nil {
+				return nil, fmt.Errorf(`unable to list resource groups of subscription "%v": %w`, subscriptionId, err)
+			}
+		}
+
+		for _, resourceGroup := range resourceGroupNames {
+			if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(resourceGroup)); matched {
+				addResourceGroup(resourceGroup)
+			}
+		}
+	}
+
+	return resourceGroupList, nil
+}
+
+func (sd *AzureServiceDiscovery) fetchResourceGroupList(subscriptionId string) (resourceGroupList []string, err error) {
+	cache := sd.prober.serviceDiscoveryCache.cache
+	cacheKey := "resourcegroups:" + strings.ToLower(subscriptionId)
+
+	if cache != nil {
+		if cacheData, ok := cache.Get(cacheKey); ok {
+			if err := json.Unmarshal(cacheData, &resourceGroupList); err == nil {
+				sd.prober.logger.Debug("using resource groups from cache", slog.String("subscriptionID", subscriptionId))
+				return resourceGroupList, nil
+			}
+		}
+	}
+
+	client, err := armresources.NewResourceGroupsClient(subscriptionId, sd.prober.AzureClient.GetCred(), sd.prober.AzureClient.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	// empty (non nil) list, so subscriptions without resource groups are not listed again for the next pattern
+	resourceGroupList = []string{}
+
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		result, err := pager.NextPage(sd.prober.ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resourceGroup := range result.Value {
+			resourceGroupList = append(resourceGroupList, to.String(resourceGroup.Name))
+		}
+	}
+
+	// store to cache (if enabled)
+	if cache != nil {
+		if cacheData, err := json.Marshal(resourceGroupList); err == nil {
+			if err := cache.Set(cacheKey, cacheData, *sd.prober.serviceDiscoveryCache.cacheDuration); err != nil {
+				sd.prober.logger.Warn("unable to save resource groups to cache", slog.Any("error", err.Error()))
+			}
+		}
+	}
+
+	return resourceGroupList, nil
+}
+
+// isGlobPattern returns true if value contains glob metacharacters
+func isGlobPattern(value string) bool {
+	return strings.ContainsAny(value, "*?[")
+}
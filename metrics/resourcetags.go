@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+// addResourceTagsToLabels adds the configured resource tags (--azure.resource-tag) as labels,
+// tags of discovered targets are used directly, the TagManager (additional lookups) is only used
+// for targets without discovered tags or tags which need the resourceGroup or subscription
+func (p *MetricProber) addResourceTagsToLabels(labels prometheus.Labels, target *MetricProbeTarget) prometheus.Labels {
+	if p.AzureResourceTagManager == nil {
+		return labels
+	}
+
+	if target.Tags == nil || !p.resourceTagsFromTarget(labels, target) {
+		return p.AzureResourceTagManager.AddResourceTagsToPrometheusLabels(p.ctx, labels, target.ResourceId)
+	}
+
+	return labels
+}
+
+// resourceTagsFromTarget sets tag labels from discovered tags, returns false if a tag can't be resolved
+// from the resource itself (source resourceGroup/subscription or inherited empty tag)
+func (p *MetricProber) resourceTagsFromTarget(labels prometheus.Labels, target *MetricProbeTarget) bool {
+	tagLabels := prometheus.Labels{}
+
+	for _, tagConfig := range p.AzureResourceTagManager.Tags {
+		switch tagConfig.Source {
+		case "", armclient.AzureTagSourceResource:
+		default:
+			return false
+		}
+
+		tagValue := strings.TrimSpace(target.Tags[tagConfig.Name])
+		if tagValue == "" && tagConfig.Inherit {
+			return false
+		}
+
+		if tagConfig.Transform.ToLower {
+			tagValue = strings.ToLower(tagValue)
+		}
+
+		if tagConfig.Transform.ToUpper {
+			tagValue = strings.ToUpper(tagValue)
+		}
+
+		tagLabels[tagConfig.TargetName] = tagValue
+	}
+
+	for labelName, labelValue := range tagLabels {
+		labels[labelName] = labelValue
+	}
+
+	return true
+}
@@ -11,10 +11,15 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/webdevops/go-common/utils/to"
+
+	"github.com/webdevops/azure-metrics-exporter/cache"
 )
 
 const (
 	ResourceGraphQueryTop = 1000
+
+	// prefix of projected resourcegraph columns for labels (avoids conflicts with id, location and tags)
+	ResourceGraphLabelColumnPrefix = "label_"
 )
 
 type (
@@ -34,61 +39,130 @@ func (sd *AzureServiceDiscovery) ResourcesClient(subscriptionId string) (*armres
 }
 
 func (sd *AzureServiceDiscovery) publishTargetList(targetList []MetricProbeTarget) {
+	if sd.prober.settings.Exclude.IsEnabled() {
+		filteredTargetList := []MetricProbeTarget{}
+		for _, target := range targetList {
+			if reason := sd.prober.settings.Exclude.Match(&target); reason != "" {
+				sd.prober.logger.Debug("excluded resource from servicediscovery", slog.String("resourceID", target.ResourceId), slog.String("reason", reason))
+				sd.prober.countExcludedTarget(reason)
+				continue
+			}
+			filteredTargetList = append(filteredTargetList, target)
+		}
+		targetList = filteredTargetList
+	}
+
 	sd.prober.AddTarget(targetList...)
 }
 
-func (sd *AzureServiceDiscovery) fetchResourceList(subscriptionId, filter string) (resourceList []AzureResource, err error) {
+// fetchSubscriptionResourceList lists the resources of a subscription, scoped to the requested resource groups (if set)
+func (sd *AzureServiceDiscovery) fetchSubscriptionResourceList(subscriptionId, filter string) (resourceList []AzureResource, err error) {
+	if len(sd.prober.settings.ResourceGroups) == 0 {
+		return sd.fetchResourceList(subscriptionId, "", filter)
+	}
+
+	resourceGroupList, err := sd.resolveResourceGroups(subscriptionId)
+	if err != nil {
+		return resourceList, err
+	}
+
+	for _, resourceGroup := range resourceGroupList {
+		list, err := sd.fetchResourceList(subscriptionId, resourceGroup, filter)
+		if err != nil {
+			return resourceList, err
+		}
+		resourceList = append(resourceList, list...)
+	}
+
+	return resourceList, nil
+}
+
+// fetchResourceList lists the resources of a subscription or a resource group (if resourceGroup is not empty)
+func (sd *AzureServiceDiscovery) fetchResourceList(subscriptionId, resourceGroup, filter string) (resourceList []AzureResource, err error) {
 	// nolint:gosec
 	cacheKey := fmt.Sprintf(
 		"%x",
-		sha256.Sum256([]byte(fmt.Sprintf("%v:%v", subscriptionId, filter))),
+		sha256.Sum256([]byte(fmt.Sprintf("%v:%v:%v", subscriptionId, strings.ToLower(resourceGroup), filter))),
 	)
 
 	// try to fetch info from cache
 	if cachedResourceList, ok := sd.fetchFromCache(cacheKey); !ok {
+		// shared cache backend: only one exporter instance fetches the resource list
+		cachedResourceList, ok, unlock := sd.lockOrWaitForCache(cacheKey)
+		if ok {
+			sd.prober.logger.Debug("using servicediscovery of other exporter instance")
+			return cachedResourceList, nil
+		}
+		defer unlock()
+
 		client, err := sd.ResourcesClient(subscriptionId)
 		if err != nil {
 			err = fmt.Errorf("servicediscovery failed: %w", err)
 			return resourceList, err
 		}
 
-		opts := armresources.ClientListOptions{
+		result, err := sd.listResources(client, resourceGroup, filter)
+		if err != nil {
+			err = fmt.Errorf("servicediscovery failed: %w", err)
+			return resourceList, err
+		}
+
+		for _, row := range result {
+			resource := row
+
+			resourceList = append(
+				resourceList,
+				AzureResource{
+					ID:       to.String(resource.ID),
+					Location: to.String(resource.Location),
+					Tags:     to.StringMap(resource.Tags),
+				},
+			)
+		}
+
+		// store to cache (if enabled)
+		sd.saveToCache(cacheKey, resourceList)
+	} else {
+		sd.prober.logger.Debug("using servicediscovery from cache")
+		resourceList = cachedResourceList
+	}
+
+	return
+}
+
+// listResources lists all resources of a subscription or a resource group (if resourceGroup is not empty)
+func (sd *AzureServiceDiscovery) listResources(client *armresources.Client, resourceGroup, filter string) (resourceList []*armresources.GenericResourceExpanded, err error) {
+	if resourceGroup != "" {
+		opts := armresources.ClientListByResourceGroupOptions{
 			Filter: to.StringPtr(filter),
 		}
-		pager := client.NewListPager(&opts)
+		pager := client.NewListByResourceGroupPager(resourceGroup, &opts)
 
 		for pager.More() {
 			result, err := pager.NextPage(sd.prober.ctx)
 			if err != nil {
-				err = fmt.Errorf("servicediscovery failed: %w", err)
 				return resourceList, err
 			}
+			resourceList = append(resourceList, result.Value...)
+		}
 
-			if result.Value == nil {
-				continue
-			}
+		return resourceList, nil
+	}
 
-			for _, row := range result.Value {
-				resource := row
+	opts := armresources.ClientListOptions{
+		Filter: to.StringPtr(filter),
+	}
+	pager := client.NewListPager(&opts)
 
-				resourceList = append(
-					resourceList,
-					AzureResource{
-						ID:   to.String(resource.ID),
-						Tags: to.StringMap(resource.Tags),
-					},
-				)
-			}
+	for pager.More() {
+		result, err := pager.NextPage(sd.prober.ctx)
+		if err != nil {
+			return resourceList, err
 		}
-
-		// store to cache (if enabled)
-		sd.saveToCache(cacheKey, resourceList)
-	} else {
-		sd.prober.logger.Debug("using servicediscovery from cache")
-		resourceList = cachedResourceList
+		resourceList = append(resourceList, result.Value...)
 	}
 
-	return
+	return resourceList, nil
 }
 
 func (sd *AzureServiceDiscovery) fetchFromCache(cacheKey string) (resourceList []AzureResource, status bool) {
@@ -96,13 +170,11 @@ func (sd *AzureServiceDiscovery) fetchFromCache(cacheKey string) (resourceList [
 	cache := sd.prober.serviceDiscoveryCache.cache
 
 	if cache != nil {
-		if v, ok := cache.Get(cacheKey); ok {
-			if cacheData, ok := v.([]byte); ok {
-				if err := json.Unmarshal(cacheData, &resourceList); err == nil {
-					status = true
-				} else {
-					contextLogger.Debug("unable to parse cached servicediscovery")
-				}
+		if cacheData, ok := cache.Get(cacheKey); ok {
+			if err := json.Unmarshal(cacheData, &resourceList); err == nil {
+				status = true
+			} else {
+				contextLogger.Debug("unable to parse cached servicediscovery")
 			}
 		}
 	}
@@ -110,6 +182,25 @@ func (sd *AzureServiceDiscovery) fetchFromCache(cacheKey string) (resourceList [
 	return
 }
 
+// lockOrWaitForCache acquires the refresh lock of cacheKey or waits for the result of the exporter instance holding the lock
+func (sd *AzureServiceDiscovery) lockOrWaitForCache(cacheKey string) (resourceList []AzureResource, status bool, unlock func()) {
+	if sd.prober.serviceDiscoveryCache.cache == nil {
+		return nil, false, func() {}
+	}
+
+	cacheData, unlock := cache.LockOrWait(sd.prober.ctx, sd.prober.serviceDiscoveryCache.cache, cacheKey, sd.prober.cacheLockTtl())
+	if unlock != nil {
+		return nil, false, unlock
+	}
+
+	if err := json.Unmarshal(cacheData, &resourceList); err != nil {
+		sd.prober.logger.Debug("unable to parse cached servicediscovery")
+		return nil, false, func() {}
+	}
+
+	return resourceList, true, nil
+}
+
 func (sd *AzureServiceDiscovery) saveToCache(cacheKey string, resourceList []AzureResource) {
 	contextLogger := sd.prober.logger
 	cache := sd.prober.serviceDiscoveryCache.cache
@@ -119,8 +210,11 @@ func (sd *AzureServiceDiscovery) saveToCache(cacheKey string, resourceList []Azu
 	if cache != nil {
 		contextLogger.Debug("saving servicedisccovery to cache")
 		if cacheData, err := json.Marshal(resourceList); err == nil {
-			cache.Set(cacheKey, cacheData, *cacheDuration)
-			contextLogger.Debug("saved servicediscovery to cache", slog.Duration("cacheDuration", *cacheDuration))
+			if err := cache.Set(cacheKey, cacheData, *cacheDuration); err == nil {
+				contextLogger.Debug("saved servicediscovery to cache", slog.Duration("cacheDuration", *cacheDuration))
+			} else {
+				contextLogger.Warn("unable to save servicediscovery to cache", slog.Any("error", err.Error()))
+			}
 		}
 	}
 }
@@ -128,12 +222,13 @@ func (sd *AzureServiceDiscovery) saveToCache(cacheKey string, resourceList []Azu
 func (sd *AzureServiceDiscovery) FindSubscriptionResources(subscriptionId, filter string) {
 	var targetList []MetricProbeTarget
 
-	if resourceList, err := sd.fetchResourceList(subscriptionId, filter); err == nil {
+	if resourceList, err := sd.fetchSubscriptionResourceList(subscriptionId, filter); err == nil {
 		for _, resource := range resourceList {
 			targetList = append(
 				targetList,
 				MetricProbeTarget{
 					ResourceId:   resource.ID,
+					Location:     resource.Location,
 					Metrics:      sd.prober.settings.Metrics,
 					Aggregations: sd.prober.settings.Aggregations,
 					Tags:         resource.Tags,
@@ -141,7 +236,7 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResources(subscriptionId, filte
 			)
 		}
 	} else {
-		sd.prober.logger.Error(err.Error())
+		sd.prober.addDiscoveryError(subscriptionId, err)
 		return
 	}
 
@@ -151,7 +246,7 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResources(subscriptionId, filte
 func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx context.Context, subscriptionId, filter, metricTagName, aggregationTagName string) {
 	var targetList []MetricProbeTarget
 
-	if resourceList, err := sd.fetchResourceList(subscriptionId, filter); err == nil {
+	if resourceList, err := sd.fetchSubscriptionResourceList(subscriptionId, filter); err == nil {
 		for _, resource := range resourceList {
 			if metrics, ok := resource.Tags[metricTagName]; ok && metrics != "" {
 				if aggregations, ok := resource.Tags[aggregationTagName]; ok && aggregations != "" {
@@ -159,8 +254,10 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx con
 						targetList,
 						MetricProbeTarget{
 							ResourceId:   resource.ID,
+							Location:     resource.Location,
 							Metrics:      stringToStringList(metrics, ","),
 							Aggregations: stringToStringList(aggregations, ","),
+							Tags:         resource.Tags,
 						},
 					)
 
@@ -168,7 +265,7 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx con
 			}
 		}
 	} else {
-		sd.prober.logger.Error(err.Error())
+		sd.prober.addDiscoveryError(subscriptionId, err)
 		return
 	}
 
@@ -176,25 +273,53 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx con
 }
 
 func (sd *AzureServiceDiscovery) FindResourceGraph(ctx context.Context, subscriptions []string, resourceType, filter string) error {
-	var targetList []MetricProbeTarget
-
-	client, err := armresourcegraph.NewClient(sd.prober.AzureClient.GetCred(), sd.prober.AzureClient.NewArmClientOptions())
-	if err != nil {
-		return err
-	}
-
 	if filter != "" {
 		filter = "| " + filter
 	}
 
-	queryTemplate := `Resources | where type =~ "%s" %s | project id, tags`
+	queryTemplate := `Resources | where type =~ "%s" %s | project %s`
+
+	// additional columns as labels
+	projection := []string{"id", "location", "tags"}
+	for _, label := range sd.prober.settings.Labels {
+		projection = append(projection, fmt.Sprintf("%s%s = tostring(%s)", ResourceGraphLabelColumnPrefix, label.Name, label.Column))
+	}
 
 	query := strings.TrimSpace(fmt.Sprintf(
 		queryTemplate,
 		strings.ReplaceAll(resourceType, "'", "\\'"),
 		filter,
+		strings.Join(projection, ", "),
 	))
 
+	return sd.findResourceGraphTargets(ctx, subscriptions, query)
+}
+
+// FindResourceGraphQuery uses a custom Kusto query for service discovery, the query has to return an id column,
+// optional columns are location, tags and metrics/aggregations (overriding the request settings per resource)
+func (sd *AzureServiceDiscovery) FindResourceGraphQuery(ctx context.Context, subscriptions []string, query string) error {
+	// additional columns as labels
+	extend := []string{}
+	for _, label := range sd.prober.settings.Labels {
+		extend = append(extend, fmt.Sprintf("%s%s = tostring(%s)", ResourceGraphLabelColumnPrefix, label.Name, label.Column))
+	}
+
+	query = strings.TrimSpace(query)
+	if len(extend) > 0 {
+		query = fmt.Sprintf("%s\n| extend %s", query, strings.Join(extend, ", "))
+	}
+
+	return sd.findResourceGraphTargets(ctx, subscriptions, query)
+}
+
+func (sd *AzureServiceDiscovery) findResourceGraphTargets(ctx context.Context, subscriptions []string, query string) error {
+	var targetList []MetricProbeTarget
+
+	client, err := armresourcegraph.NewClient(sd.prober.AzureClient.GetCred(), sd.prober.AzureClient.NewArmClientOptions())
+	if err != nil {
+		return err
+	}
+
 	sd.prober.logger.With(slog.String("query", query)).Debug("using Kusto query")
 
 	queryFormat := armresourcegraph.ResultFormatObjectArray
@@ -222,22 +347,31 @@ func (sd *AzureServiceDiscovery) FindResourceGraph(ctx context.Context, subscrip
 
 			for _, v := range resultList {
 				if resultRow, ok := v.(map[string]interface{}); ok {
-					// check if we got data, otherwise break the for loop
-					if len(resultList) == 0 {
-						break
-					}
-
 					if val, ok := resultRow["id"]; ok && val != "" {
 						if resourceId, ok := val.(string); ok {
-							targetList = append(
-								targetList,
-								MetricProbeTarget{
-									ResourceId:   resourceId,
-									Metrics:      sd.prober.settings.Metrics,
-									Aggregations: sd.prober.settings.Aggregations,
-									Tags:         sd.resourceTagsToStringMap(resultRow["tags"]),
-								},
-							)
+							target := MetricProbeTarget{
+								ResourceId:   resourceId,
+								Location:     sd.resourceRowToString(resultRow["location"]),
+								Metrics:      sd.prober.settings.Metrics,
+								Aggregations: sd.prober.settings.Aggregations,
+								Labels:       sd.resourceRowToLabels(resultRow),
+							}
+
+							// tags are only used if returned by query (otherwise tags are looked up by tag manager)
+							if tags, exists := resultRow["tags"]; exists {
+								target.Tags = sd.resourceTagsToStringMap(tags)
+							}
+
+							// per resource metric settings (custom query)
+							if metrics := sd.resourceRowToStringList(resultRow["metrics"]); len(metrics) > 0 {
+								target.Metrics = metrics
+							}
+
+							if aggregations := sd.resourceRowToStringList(resultRow["aggregations"]); len(aggregations) > 0 {
+								target.Aggregations = aggregations
+							}
+
+							targetList = append(targetList, target)
 						}
 					}
 				}
@@ -259,6 +393,43 @@ func (sd *AzureServiceDiscovery) FindResourceGraph(ctx context.Context, subscrip
 	return nil
 }
 
+func (sd *AzureServiceDiscovery) resourceRowToString(value interface{}) string {
+	if val, ok := value.(string); ok {
+		return val
+	}
+	return ""
+}
+
+// resourceRowToStringList converts a column (comma separated string or array) to a string list
+func (sd *AzureServiceDiscovery) resourceRowToStringList(value interface{}) (list []string) {
+	switch v := value.(type) {
+	case string:
+		if strings.TrimSpace(v) != "" {
+			list = stringToStringList(v, ",")
+		}
+	case []interface{}:
+		for _, row := range v {
+			if val, ok := row.(string); ok && strings.TrimSpace(val) != "" {
+				list = append(list, strings.TrimSpace(val))
+			}
+		}
+	}
+	return
+}
+
+// resourceRowToLabels returns the additional resourcegraph columns (parameter labels) as labels
+func (sd *AzureServiceDiscovery) resourceRowToLabels(resultRow map[string]interface{}) map[string]string {
+	if len(sd.prober.settings.Labels) == 0 {
+		return nil
+	}
+
+	labels := map[string]string{}
+	for _, label := range sd.prober.settings.Labels {
+		labels[label.Name] = sd.resourceRowToString(resultRow[ResourceGraphLabelColumnPrefix+label.Name])
+	}
+	return labels
+}
+
 func (sd *AzureServiceDiscovery) resourceTagsToStringMap(tags interface{}) (ret map[string]string) {
 	ret = map[string]string{}
 
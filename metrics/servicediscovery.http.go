@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+const (
+	HttpServiceDiscoveryLabelPrefix = "__meta_azure_"
+)
+
+type (
+	// HttpServiceDiscoveryTargetGroup is a Prometheus http_sd_config target group
+	HttpServiceDiscoveryTargetGroup struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+)
+
+// HttpServiceDiscoveryTargetGroups returns the discovered targets as Prometheus http_sd_config target groups
+// (one target group per resource, resource id as target)
+func (p *MetricProber) HttpServiceDiscoveryTargetGroups() []HttpServiceDiscoveryTargetGroup {
+	list := []HttpServiceDiscoveryTargetGroup{}
+
+	for _, targetList := range p.targets {
+		for _, target := range targetList {
+			resourceInfo, err := armclient.ParseResourceId(target.ResourceId)
+			if err != nil {
+				continue
+			}
+
+			labels := map[string]string{
+				HttpServiceDiscoveryLabelPrefix + "subscription_id":   resourceInfo.Subscription,
+				HttpServiceDiscoveryLabelPrefix + "resource_id":       target.ResourceId,
+				HttpServiceDiscoveryLabelPrefix + "resource_name":     resourceInfo.ResourceName,
+				HttpServiceDiscoveryLabelPrefix + "resource_type":     strings.TrimPrefix(resourceInfo.ResourceProvider(), "/"),
+				HttpServiceDiscoveryLabelPrefix + "resource_group":    resourceInfo.ResourceGroup,
+				HttpServiceDiscoveryLabelPrefix + "resource_location": target.Location,
+			}
+
+			if len(p.settings.ManagementGroups) > 0 {
+				labels[HttpServiceDiscoveryLabelPrefix+"management_group"] = p.managementGroupLabel(resourceInfo.Subscription)
+			}
+
+			for tagName, tagValue := range target.Tags {
+				labelName := HttpServiceDiscoveryLabelPrefix + "resource_tag_" + metricLabelNotAllowedChars.ReplaceAllString(strings.ToLower(tagName), "_")
+				labels[labelName] = tagValue
+			}
+
+			list = append(list, HttpServiceDiscoveryTargetGroup{
+				Targets: []string{target.ResourceId},
+				Labels:  labels,
+			})
+		}
+	}
+
+	// stable output for Prometheus
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Targets[0] < list[j].Targets[0]
+	})
+
+	return list
+}
@@ -3,31 +3,84 @@ package metrics
 import (
 	"fmt"
 	"net/http"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	iso8601 "github.com/channelmeter/iso8601duration"
+	"k8s.io/apimachinery/pkg/labels"
 
+	"github.com/webdevops/azure-metrics-exporter/cache"
 	"github.com/webdevops/azure-metrics-exporter/config"
 )
 
 const (
 	PrometheusMetricNameDefault = "azurerm_resource_metric"
+
+	// DatapointModeDefault publishes all datapoints, the last one wins
+	DatapointModeDefault = ""
+	// DatapointModeLast publishes only the newest non-null datapoint
+	DatapointModeLast = "last"
+	// DatapointModeTimestamp publishes all datapoints with their Azure timestamp
+	DatapointModeTimestamp = "timestamp"
+
+	// MetricWildcard expands to all metrics supported by the resource (metric definitions)
+	MetricWildcard = "*"
+
+	// SubscriptionWildcard expands to all subscriptions accessible by the exporter
+	SubscriptionWildcard = "*"
+)
+
+var (
+	// resourcegraph column (eg. kind, sku.name or properties.provisioningState)
+	resourceGraphLabelColumnRegExp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+
+	// labels set by the exporter itself, can't be used as resourcegraph labels
+	resourceGraphLabelReserved = []string{
+		"resourceID",
+		"subscriptionID",
+		"subscriptionName",
+		"resourceGroup",
+		"resourceName",
+		"metric",
+		"unit",
+		"interval",
+		"timespan",
+		"aggregation",
+		"dimension",
+		ManagementGroupLabelName,
+	}
 )
 
 type (
 	RequestMetricSettings struct {
-		Name            string
-		Subscriptions   []string
-		ResourceType    string
-		Filter          string
-		Timespan        string
-		Interval        *string
-		Metrics         []string
-		MetricNamespace string
-		Aggregations    []string
-		Regions         []string
+		Name             string
+		Subscriptions    []string
+		ManagementGroups []string
+		ResourceType     string
+		Filter           string
+		Timespan         string
+		Interval         *string
+		Metrics          []string
+		MetricExclude    []string
+		MetricNamespace  string
+		Aggregations     []string
+		Regions          []string
+
+		// resource groups (with glob support) for resource group scoped service discovery (list and scrape)
+		ResourceGroups []string
+
+		// exclusion rules for discovered resources
+		Exclude ResourceExclusion
+
+		// subscription=* (expanded to all accessible subscriptions by prober)
+		SubscriptionWildcard bool
+		SubscriptionFilter   SubscriptionFilter
+
+		// subscription tags as additional labels
+		SubscriptionTagLabels []string
 
 		// needed for dimension support
 		MetricTop     *int32
@@ -41,9 +94,45 @@ type (
 
 		DimensionLowercase bool
 
+		Datapoint string
+
+		// add Azure unit as label
+		UnitLabel bool
+
+		// convert values to prometheus base units (eg. milliseconds to seconds)
+		NormalizeUnits bool
+
+		// accumulate total and count aggregations as counters
+		Cumulative bool
+
+		// use metrics batch api (metrics:getBatch)
+		Batch bool
+
+		// additional resourcegraph columns as labels (only /probe/metrics/resourcegraph)
+		Labels []ResourceGraphLabel
+
 		// cache
 		Cache *time.Duration
 	}
+
+	// SubscriptionFilter filters the subscriptions found by subscription=*
+	SubscriptionFilter struct {
+		// subscription tags (kubernetes label selector syntax)
+		TagSelector labels.Selector
+
+		// subscription states (eg. Enabled, Warned, PastDue)
+		States []string
+
+		// regexp matched against subscription display name
+		Name *regexp.Regexp
+	}
+
+	// ResourceGraphLabel is a resourcegraph column which is added as label to all metrics of a resource
+	// (syntax: column or label=column)
+	ResourceGraphLabel struct {
+		Name   string
+		Column string
+	}
 )
 
 func NewRequestMetricSettingsForAzureResourceApi(r *http.Request, opts config.Opts) (RequestMetricSettings, error) {
@@ -52,7 +141,9 @@ func NewRequestMetricSettingsForAzureResourceApi(r *http.Request, opts config.Op
 		return settings, err
 	}
 
-	if r.URL.Path == config.ProbeMetricsResourceUrl {
+	if r.URL.Path == config.ProbeMetricsSubscriptionUrl && settings.HasMetricWildcard() {
+		return settings, fmt.Errorf("parameter \"metric\" does not support wildcard \"%v\" on subscription scope", MetricWildcard)
+	} else if r.URL.Path == config.ProbeMetricsResourceUrl {
 		return settings, nil
 	} else if settings.ResourceType != "" && settings.Filter != "" {
 		return settings, fmt.Errorf("parameter \"resourceType\" and \"filter\" are mutually exclusive")
@@ -79,11 +170,77 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 	// param name
 	ret.Name = paramsGetWithDefault(params, "name", PrometheusMetricNameDefault)
 
-	// param subscription
-	if subscriptionList, err := paramsGetListRequired(params, "subscription"); err == nil {
+	// param managementGroup (expanded to subscriptions by prober)
+	if val, err := paramsGetList(params, "managementGroup"); err == nil {
+		for _, managementGroup := range val {
+			if managementGroup != "" {
+				ret.ManagementGroups = append(ret.ManagementGroups, managementGroup)
+			}
+		}
+	} else {
+		return ret, err
+	}
+
+	// param subscription (optional if managementGroup or target is set, targets contain their subscription)
+	if len(ret.ManagementGroups) == 0 && params.Get("target") == "" {
+		if _, err := paramsGetListRequired(params, "subscription"); err != nil {
+			return ret, err
+		}
+	}
+	if subscriptionList, err := paramsGetList(params, "subscription"); err == nil {
 		for _, subscription := range subscriptionList {
 			subscription = strings.TrimSpace(subscription)
-			ret.Subscriptions = append(ret.Subscriptions, subscription)
+			switch subscription {
+			case "":
+			case SubscriptionWildcard:
+				ret.SubscriptionWildcard = true
+			default:
+				ret.Subscriptions = append(ret.Subscriptions, subscription)
+			}
+		}
+	} else {
+		return ret, err
+	}
+
+	// param subscriptionTagSelector
+	if val := params.Get("subscriptionTagSelector"); val != "" {
+		selector, err := labels.Parse(val)
+		if err != nil {
+			return ret, fmt.Errorf(`parameter "subscriptionTagSelector" is invalid: %w`, err)
+		}
+		ret.SubscriptionFilter.TagSelector = selector
+	}
+
+	// param subscriptionState
+	if val, err := paramsGetList(params, "subscriptionState"); err == nil {
+		for _, state := range val {
+			if state = strings.TrimSpace(state); state != "" {
+				ret.SubscriptionFilter.States = append(ret.SubscriptionFilter.States, state)
+			}
+		}
+	} else {
+		return ret, err
+	}
+
+	// param subscriptionNameFilter
+	if val := params.Get("subscriptionNameFilter"); val != "" {
+		nameFilter, err := regexp.Compile(val)
+		if err != nil {
+			return ret, fmt.Errorf(`parameter "subscriptionNameFilter" is invalid: %w`, err)
+		}
+		ret.SubscriptionFilter.Name = nameFilter
+	}
+
+	if !ret.SubscriptionWildcard && ret.SubscriptionFilter.IsEnabled() {
+		return ret, fmt.Errorf(`parameters "subscriptionTagSelector", "subscriptionState" and "subscriptionNameFilter" require "subscription=%v"`, SubscriptionWildcard)
+	}
+
+	// param subscriptionTagLabel
+	if val, err := paramsGetList(params, "subscriptionTagLabel"); err == nil {
+		for _, tagName := range val {
+			if tagName = strings.TrimSpace(tagName); tagName != "" {
+				ret.SubscriptionTagLabels = append(ret.SubscriptionTagLabels, tagName)
+			}
 		}
 	} else {
 		return ret, err
@@ -96,6 +253,80 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 		return ret, err
 	}
 
+	// param resourceGroup
+	if val, err := paramsGetList(params, "resourceGroup"); err == nil {
+		for _, resourceGroup := range val {
+			resourceGroup = strings.TrimSpace(resourceGroup)
+			if resourceGroup == "" {
+				continue
+			}
+
+			if _, err := path.Match(resourceGroup, ""); err != nil {
+				return ret, fmt.Errorf(`parameter "resourceGroup" has invalid pattern "%v": %w`, resourceGroup, err)
+			}
+			ret.ResourceGroups = append(ret.ResourceGroups, resourceGroup)
+		}
+	} else {
+		return ret, err
+	}
+
+	// param excludeResourceId (not split by comma, regexp)
+	for _, val := range params["excludeResourceId"] {
+		if val = strings.TrimSpace(val); val == "" {
+			continue
+		}
+
+		resourceIdRegexp, err := regexp.Compile("(?i)" + val)
+		if err != nil {
+			return ret, fmt.Errorf(`parameter "excludeResourceId" is invalid: %w`, err)
+		}
+		ret.Exclude.ResourceIds = append(ret.Exclude.ResourceIds, resourceIdRegexp)
+	}
+
+	// param excludeTag
+	if val, err := paramsGetList(params, "excludeTag"); err == nil {
+		for _, tag := range val {
+			if tag == "" {
+				continue
+			}
+
+			excludeTag, err := parseResourceExclusionTag(tag)
+			if err != nil {
+				return ret, err
+			}
+			ret.Exclude.Tags = append(ret.Exclude.Tags, excludeTag)
+		}
+	} else {
+		return ret, err
+	}
+
+	// param excludeLocation
+	if val, err := paramsGetList(params, "excludeLocation"); err == nil {
+		for _, location := range val {
+			if location = strings.TrimSpace(location); location != "" {
+				ret.Exclude.Locations = append(ret.Exclude.Locations, location)
+			}
+		}
+	} else {
+		return ret, err
+	}
+
+	// param excludeResourceGroup
+	if val, err := paramsGetList(params, "excludeResourceGroup"); err == nil {
+		for _, resourceGroup := range val {
+			if resourceGroup = strings.TrimSpace(resourceGroup); resourceGroup == "" {
+				continue
+			}
+
+			if _, err := path.Match(resourceGroup, ""); err != nil {
+				return ret, fmt.Errorf(`parameter "excludeResourceGroup" has invalid pattern "%v": %w`, resourceGroup, err)
+			}
+			ret.Exclude.ResourceGroups = append(ret.Exclude.ResourceGroups, resourceGroup)
+		}
+	} else {
+		return ret, err
+	}
+
 	// param filter
 	ret.ResourceType = paramsGetWithDefault(params, "resourceType", "")
 	ret.Filter = paramsGetWithDefault(params, "filter", "")
@@ -113,13 +344,29 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 		ret.Interval = &val
 	}
 
-	// param metric
+	// param metric (with optional per metric settings)
 	if val, err := paramsGetList(params, "metric"); err == nil {
+		for _, metric := range val {
+			if metric == MetricWildcard {
+				continue
+			}
+
+			if _, err := parseMetricSpec(metric); err != nil {
+				return ret, err
+			}
+		}
 		ret.Metrics = val
 	} else {
 		return ret, err
 	}
 
+	// param metricExclude
+	if val, err := paramsGetList(params, "metricExclude"); err == nil {
+		ret.MetricExclude = val
+	} else {
+		return ret, err
+	}
+
 	// param metricNamespace
 	ret.MetricNamespace = paramsGetWithDefault(params, "metricNamespace", "")
 
@@ -146,6 +393,64 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 	// param metricOrderBy
 	ret.MetricOrderBy = paramsGetWithDefault(params, "metricOrderBy", "")
 
+	// param batch
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "batch", "false")); err == nil {
+		ret.Batch = val
+	} else {
+		return ret, err
+	}
+
+	// param labels
+	if val, err := paramsGetList(params, "labels"); err == nil {
+		for _, label := range val {
+			if label == "" {
+				continue
+			}
+
+			resourceGraphLabel, err := parseResourceGraphLabel(label)
+			if err != nil {
+				return ret, err
+			}
+			ret.Labels = append(ret.Labels, resourceGraphLabel)
+		}
+	} else {
+		return ret, err
+	}
+
+	// param datapoint
+	ret.Datapoint = paramsGetWithDefault(params, "datapoint", DatapointModeDefault)
+	switch ret.Datapoint {
+	case DatapointModeDefault, DatapointModeLast, DatapointModeTimestamp:
+	default:
+		return ret, fmt.Errorf("parameter \"datapoint\" has invalid value \"%v\"", ret.Datapoint)
+	}
+
+	// param unitLabel
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "unitLabel", "true")); err == nil {
+		ret.UnitLabel = val
+	} else {
+		return ret, err
+	}
+
+	// param normalizeUnits
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "normalizeUnits", "false")); err == nil {
+		ret.NormalizeUnits = val
+	} else {
+		return ret, err
+	}
+
+	// param cumulative
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "cumulative", "false")); err == nil {
+		ret.Cumulative = val
+	} else {
+		return ret, err
+	}
+
+	// accumulated counters are kept per exporter instance, instances sharing the cache would serve different counter values
+	if ret.Cumulative && opts.Prober.CacheBackend == cache.BackendRedis {
+		return ret, fmt.Errorf(`parameter "cumulative" is not supported with shared cache backend "%v"`, opts.Prober.CacheBackend)
+	}
+
 	// param template
 	ret.MetricTemplate = paramsGetWithDefault(params, "template", opts.Metrics.Template)
 
@@ -194,3 +499,43 @@ func (s *RequestMetricSettings) SetMetrics(val string) {
 func (s *RequestMetricSettings) SetAggregations(val string) {
 	s.Aggregations = stringToStringList(val, ",")
 }
+
+// HasMetricWildcard returns true if metrics should be expanded to all metrics supported by the resource
+func (s *RequestMetricSettings) HasMetricWildcard() bool {
+	return isMetricWildcard(s.Metrics)
+}
+
+// IsEnabled returns true if any subscription filter is set
+func (f *SubscriptionFilter) IsEnabled() bool {
+	return f.TagSelector != nil || len(f.States) > 0 || f.Name != nil
+}
+
+// parseResourceGraphLabel parses a resourcegraph label (syntax: column or label=column),
+// label name defaults to the column with invalid chars replaced by "_"
+func parseResourceGraphLabel(value string) (label ResourceGraphLabel, err error) {
+	label.Column = strings.TrimSpace(value)
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		label.Name = strings.TrimSpace(parts[0])
+		label.Column = strings.TrimSpace(parts[1])
+	}
+
+	if !resourceGraphLabelColumnRegExp.MatchString(label.Column) {
+		return label, fmt.Errorf(`parameter "labels" has invalid column "%v"`, label.Column)
+	}
+
+	if label.Name == "" {
+		label.Name = label.Column
+	}
+	label.Name = metricLabelNotAllowedChars.ReplaceAllString(label.Name, "_")
+	if label.Name[0] >= '0' && label.Name[0] <= '9' {
+		return label, fmt.Errorf(`parameter "labels" has invalid label name "%v"`, label.Name)
+	}
+
+	for _, reservedLabel := range resourceGraphLabelReserved {
+		if label.Name == reservedLabel {
+			return label, fmt.Errorf(`parameter "labels" uses reserved label "%v", use "<label>=%v" to rename it`, label.Name, label.Column)
+		}
+	}
+
+	return label, nil
+}
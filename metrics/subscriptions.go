@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/prometheus/client_golang/prometheus"
+	stringsCommon "github.com/webdevops/go-common/strings"
+	"github.com/webdevops/go-common/utils/to"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	SubscriptionTagLabelPrefix = "subscriptionTag"
+)
+
+// ResolveSubscriptions expands the subscription list by subscription=* and requested management groups
+func (p *MetricProber) ResolveSubscriptions() error {
+	if p.subscriptionsResolved {
+		return nil
+	}
+
+	if err := p.resolveSubscriptionWildcard(); err != nil {
+		return err
+	}
+
+	if err := p.resolveManagementGroups(); err != nil {
+		return err
+	}
+
+	if (p.settings.SubscriptionWildcard || len(p.settings.ManagementGroups) > 0) && len(p.settings.Subscriptions) == 0 {
+		return fmt.Errorf("no subscriptions found")
+	}
+
+	p.subscriptionsResolved = true
+	return nil
+}
+
+// resolveSubscriptionWildcard adds all accessible subscriptions (matching the subscription filter) to the subscription list
+func (p *MetricProber) resolveSubscriptionWildcard() error {
+	if !p.settings.SubscriptionWildcard {
+		return nil
+	}
+
+	subscriptionList, err := p.AzureClient.ListCachedSubscriptions(p.ctx)
+	if err != nil {
+		return fmt.Errorf(`unable to list subscriptions: %w`, err)
+	}
+
+	// add subscriptions which are not already requested by parameter subscription
+	subscriptions := map[string]bool{}
+	for _, subscriptionId := range p.settings.Subscriptions {
+		subscriptions[strings.ToLower(subscriptionId)] = true
+	}
+
+	foundSubscriptions := 0
+	for _, subscription := range subscriptionList {
+		if !p.settings.SubscriptionFilter.Matches(subscription) {
+			continue
+		}
+
+		foundSubscriptions++
+		subscriptionId := to.String(subscription.SubscriptionID)
+		if !subscriptions[strings.ToLower(subscriptionId)] {
+			subscriptions[strings.ToLower(subscriptionId)] = true
+			p.settings.Subscriptions = append(p.settings.Subscriptions, subscriptionId)
+		}
+	}
+
+	p.logger.Debug(fmt.Sprintf("found %v of %v accessible subscriptions", foundSubscriptions, len(subscriptionList)))
+
+	return nil
+}
+
+// Matches returns true if the subscription matches all filters
+func (f *SubscriptionFilter) Matches(subscription *armsubscriptions.Subscription) bool {
+	if f.TagSelector != nil && !f.TagSelector.Matches(labels.Set(to.StringMap(subscription.Tags))) {
+		return false
+	}
+
+	if len(f.States) > 0 {
+		stateMatch := false
+		if subscription.State != nil {
+			for _, state := range f.States {
+				if strings.EqualFold(state, string(*subscription.State)) {
+					stateMatch = true
+					break
+				}
+			}
+		}
+
+		if !stateMatch {
+			return false
+		}
+	}
+
+	if f.Name != nil && !f.Name.MatchString(to.String(subscription.DisplayName)) {
+		return false
+	}
+
+	return true
+}
+
+// addSubscriptionTagLabels adds the requested subscription tags as labels (subscriptionTagXyz="value")
+func (p *MetricProber) addSubscriptionTagLabels(metricLabels prometheus.Labels, subscription *armsubscriptions.Subscription) {
+	for _, tagName := range p.settings.SubscriptionTagLabels {
+		tagValue := ""
+		if subscription != nil {
+			for name, value := range subscription.Tags {
+				if strings.EqualFold(name, tagName) {
+					tagValue = to.String(value)
+					break
+				}
+			}
+		}
+
+		metricLabels[subscriptionTagLabelName(tagName)] = tagValue
+	}
+}
+
+// subscriptionTagLabelName returns the label name of a subscription tag (eg. subscriptionTagCostcenter)
+func subscriptionTagLabelName(tagName string) string {
+	return metricLabelNotAllowedChars.ReplaceAllString(SubscriptionTagLabelPrefix+stringsCommon.UppercaseFirst(strings.ToLower(tagName)), "")
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+type (
+	// normalizedUnit is the prometheus base unit of an Azure unit, values are multiplied by factor
+	normalizedUnit struct {
+		unit   string
+		factor float64
+	}
+)
+
+var (
+	// Azure units converted to prometheus base units (https://prometheus.io/docs/practices/naming/#base-units)
+	normalizedUnits = map[string]normalizedUnit{
+		strings.ToLower(string(armmonitor.UnitBytes)):          {"bytes", 1},
+		strings.ToLower(string(armmonitor.UnitBytesPerSecond)): {"bytes_per_second", 1},
+		strings.ToLower(string(armmonitor.UnitBitsPerSecond)):  {"bytes_per_second", 1.0 / 8},
+		strings.ToLower(string(armmonitor.UnitByteSeconds)):    {"byte_seconds", 1},
+		strings.ToLower(string(armmonitor.UnitSeconds)):        {"seconds", 1},
+		strings.ToLower(string(armmonitor.UnitMilliSeconds)):   {"seconds", 1e-3},
+		strings.ToLower(string(armmonitor.UnitPercent)):        {"ratio", 1e-2},
+		strings.ToLower(string(armmonitor.UnitCountPerSecond)): {"per_second", 1},
+		strings.ToLower(string(armmonitor.UnitCores)):          {"cores", 1},
+		strings.ToLower(string(armmonitor.UnitMilliCores)):     {"cores", 1e-3},
+		strings.ToLower(string(armmonitor.UnitNanoCores)):      {"cores", 1e-9},
+		strings.ToLower(string(armmonitor.UnitCount)):          {"", 1},
+		strings.ToLower(string(armmonitor.UnitUnspecified)):    {"", 1},
+	}
+)
+
+// normalizeUnit returns the prometheus base unit and conversion factor of an Azure unit,
+// unknown units are used as they are (without conversion)
+func normalizeUnit(azureUnit string) (unit string, factor float64) {
+	if val, exists := normalizedUnits[strings.ToLower(azureUnit)]; exists {
+		return val.unit, val.factor
+	}
+	return azureUnit, 1
+}
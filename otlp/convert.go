@@ -0,0 +1,185 @@
+package otlp
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	ScopeName = "github.com/webdevops/azure-metrics-exporter"
+
+	resourceTagAttributePrefix = "azure.resource.tag."
+)
+
+var (
+	// labels which describe the Azure resource and are exported as OTLP resource attributes
+	resourceAttributeLabels = map[string]string{
+		"resourceID":       "cloud.resource_id",
+		"subscriptionID":   "cloud.account.id",
+		"subscriptionName": "azure.subscription.name",
+		"resourceGroup":    "azure.resource_group.name",
+		"resourceName":     "azure.resource.name",
+	}
+
+	// labels which are not exported as datapoint attributes (unit is part of the OTLP metric)
+	skippedDatapointLabels = map[string]bool{
+		"unit": true,
+	}
+)
+
+type (
+	// resourceMetrics collects the metrics of one Azure resource (same resource attributes)
+	resourceMetrics struct {
+		proto   *metricspb.ResourceMetrics
+		scope   *metricspb.ScopeMetrics
+		metrics map[string]*metricspb.Metric
+	}
+)
+
+// buildExportRequest converts a metric list into an OTLP export request (one resource per Azure resource),
+// rows without Azure timestamp use the collection time
+func buildExportRequest(metricList *metrics.MetricList, collectTime time.Time, scopeVersion string) (request *colmetricspb.ExportMetricsServiceRequest, datapoints int) {
+	request = &colmetricspb.ExportMetricsServiceRequest{}
+
+	resourceIndex := map[string]*resourceMetrics{}
+
+	metricNames := metricList.GetMetricNames()
+	sort.Strings(metricNames)
+
+	for _, metricName := range metricNames {
+		for _, row := range metricList.GetMetricList(metricName) {
+			resourceAttributes, datapointAttributes := splitAttributes(row.Labels)
+
+			resourceKey := attributesKey(resourceAttributes)
+			resource, exists := resourceIndex[resourceKey]
+			if !exists {
+				resource = &resourceMetrics{
+					proto: &metricspb.ResourceMetrics{
+						Resource: &resourcepb.Resource{
+							Attributes: append([]*commonpb.KeyValue{stringAttribute("cloud.provider", "azure")}, resourceAttributes...),
+						},
+					},
+					scope: &metricspb.ScopeMetrics{
+						Scope: &commonpb.InstrumentationScope{
+							Name:    ScopeName,
+							Version: scopeVersion,
+						},
+					},
+					metrics: map[string]*metricspb.Metric{},
+				}
+				resource.proto.ScopeMetrics = []*metricspb.ScopeMetrics{resource.scope}
+				resourceIndex[resourceKey] = resource
+				request.ResourceMetrics = append(request.ResourceMetrics, resource.proto)
+			}
+
+			metric, exists := resource.metrics[metricName]
+			if !exists {
+				metric = newMetric(metricName, metricList.GetMetricHelp(metricName), row)
+				resource.metrics[metricName] = metric
+				resource.scope.Metrics = append(resource.scope.Metrics, metric)
+			}
+
+			timestamp := collectTime
+			if row.Timestamp != nil {
+				timestamp = *row.Timestamp
+			}
+
+			datapoint := &metricspb.NumberDataPoint{
+				Attributes:   datapointAttributes,
+				TimeUnixNano: uint64(timestamp.UnixNano()), // nolint:gosec // timestamps are always after 1970
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: row.Value},
+			}
+
+			// cumulative values need the start of accumulation
+			if row.Counter && row.StartTimestamp != nil {
+				datapoint.StartTimeUnixNano = uint64(row.StartTimestamp.UnixNano()) // nolint:gosec // timestamps are always after 1970
+			}
+
+			switch data := metric.Data.(type) {
+			case *metricspb.Metric_Sum:
+				data.Sum.DataPoints = append(data.Sum.DataPoints, datapoint)
+			case *metricspb.Metric_Gauge:
+				data.Gauge.DataPoints = append(data.Gauge.DataPoints, datapoint)
+			}
+			datapoints++
+		}
+	}
+
+	return request, datapoints
+}
+
+// newMetric creates an OTLP metric (with UCUM unit), cumulative rows (counters) are exported as monotonic sum, all others as gauge
+func newMetric(name, help string, row metrics.MetricRow) *metricspb.Metric {
+	metric := &metricspb.Metric{
+		Name:        name,
+		Description: help,
+		Unit:        ucumUnit(row.AzureUnit),
+	}
+
+	if row.Counter {
+		metric.Data = &metricspb.Metric_Sum{
+			Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			},
+		}
+	} else {
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	}
+
+	return metric
+}
+
+// splitAttributes splits metric labels into resource attributes (resource id, subscription, resource group and tags)
+// and datapoint attributes (sorted, empty labels are dropped)
+func splitAttributes(labels map[string]string) (resourceAttributes, datapointAttributes []*commonpb.KeyValue) {
+	labelNames := make([]string, 0, len(labels))
+	for labelName := range labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	for _, labelName := range labelNames {
+		labelValue := labels[labelName]
+		if labelValue == "" || skippedDatapointLabels[labelName] {
+			continue
+		}
+
+		switch {
+		case resourceAttributeLabels[labelName] != "":
+			resourceAttributes = append(resourceAttributes, stringAttribute(resourceAttributeLabels[labelName], labelValue))
+		case strings.HasPrefix(labelName, armclient.AzurePrometheusLabelPrefix):
+			tagName := strings.TrimPrefix(labelName, armclient.AzurePrometheusLabelPrefix)
+			resourceAttributes = append(resourceAttributes, stringAttribute(resourceTagAttributePrefix+tagName, labelValue))
+		default:
+			datapointAttributes = append(datapointAttributes, stringAttribute(labelName, labelValue))
+		}
+	}
+
+	return
+}
+
+// attributesKey returns a unique key of (sorted) attributes
+func attributesKey(attributes []*commonpb.KeyValue) string {
+	parts := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		parts = append(parts, attribute.Key+"="+attribute.Value.GetStringValue())
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
@@ -0,0 +1,96 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func TestUcumUnit(t *testing.T) {
+	testCases := map[string]string{
+		"":                 "",
+		"Unspecified":      "",
+		"Seconds":          "s",
+		"seconds":          "s",
+		"MilliSeconds":     "ms",
+		"Percent":          "%",
+		"ratio":            "1",
+		"Count":            "1",
+		"Bytes":            "By",
+		"BytesPerSecond":   "By/s",
+		"bytes_per_second": "By/s",
+		"CountPerSecond":   "1/s",
+		"Custom":           "{Custom}",
+	}
+
+	for unit, expected := range testCases {
+		if value := ucumUnit(unit); value != expected {
+			t.Errorf(`expected UCUM unit "%v" for "%v", got "%v"`, expected, unit, value)
+		}
+	}
+}
+
+func TestBuildExportRequest(t *testing.T) {
+	startTime := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	metricList := metrics.NewMetricList()
+	metricList.Add(
+		"azurerm_requests_total",
+		metrics.MetricRow{
+			Labels:         prometheus.Labels{"resourceID": "a", "metric": "Requests", "unit": "Count"},
+			Value:          10,
+			Timestamp:      &timestamp,
+			AzureUnit:      "Count",
+			Counter:        true,
+			StartTimestamp: &startTime,
+		},
+	)
+	metricList.Add(
+		"azurerm_latency",
+		metrics.MetricRow{
+			Labels:    prometheus.Labels{"resourceID": "a", "metric": "Latency", "unit": "MilliSeconds"},
+			Value:     5,
+			Timestamp: &timestamp,
+			AzureUnit: "MilliSeconds",
+		},
+	)
+
+	request, datapoints := buildExportRequest(metricList, time.Now(), "test")
+	if datapoints != 2 || len(request.ResourceMetrics) != 1 {
+		t.Fatalf("expected 2 datapoints of one resource, got %v datapoints of %v resources", datapoints, len(request.ResourceMetrics))
+	}
+
+	for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		switch metric.Name {
+		case "azurerm_requests_total":
+			sum := metric.GetSum()
+			if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+				t.Fatalf("expected monotonic cumulative sum, got %v", metric)
+			}
+			if metric.Unit != "1" {
+				t.Errorf(`expected unit "1", got "%v"`, metric.Unit)
+			}
+			if value := sum.DataPoints[0].StartTimeUnixNano; value != uint64(startTime.UnixNano()) {
+				t.Errorf("expected start time %v, got %v", startTime, time.Unix(0, int64(value)))
+			}
+		case "azurerm_latency":
+			gauge := metric.GetGauge()
+			if gauge == nil {
+				t.Fatalf("expected gauge, got %v", metric)
+			}
+			if metric.Unit != "ms" {
+				t.Errorf(`expected unit "ms", got "%v"`, metric.Unit)
+			}
+			if value := gauge.DataPoints[0].StartTimeUnixNano; value != 0 {
+				t.Errorf("expected no start time for gauge, got %v", value)
+			}
+		default:
+			t.Errorf("unexpected metric %v", metric.Name)
+		}
+	}
+}
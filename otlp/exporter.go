@@ -0,0 +1,329 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	ProtocolHttpProtobuf = "http/protobuf"
+	ProtocolGrpc         = "grpc"
+
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+
+	// maximum length of response body used in error messages
+	errorBodyLimit = 512
+)
+
+type (
+	// Exporter exports collected metric lists to an OTLP endpoint (OTLP/HTTP or OTLP/gRPC)
+	Exporter struct {
+		conf         config.Opts
+		userAgent    string
+		scopeVersion string
+		logger       *slog.Logger
+		headers      map[string]string
+
+		httpClient *http.Client
+		grpcClient colmetricspb.MetricsServiceClient
+
+		queue chan *exportRequest
+
+		prometheus struct {
+			queueLength prometheus.Gauge
+			datapoints  *prometheus.CounterVec
+			requests    *prometheus.CounterVec
+		}
+	}
+
+	exportRequest struct {
+		request    *colmetricspb.ExportMetricsServiceRequest
+		datapoints int
+	}
+)
+
+// New creates an OTLP exporter (nil if OTLP export is not configured) and starts the queue worker
+func New(conf config.Opts, userAgent, scopeVersion string, logger *slog.Logger) (*Exporter, error) {
+	if conf.OTLP.Endpoint == "" {
+		return nil, nil
+	}
+
+	e := &Exporter{
+		conf:         conf,
+		userAgent:    userAgent,
+		scopeVersion: scopeVersion,
+		logger:       logger,
+		headers:      map[string]string{},
+		queue:        make(chan *exportRequest, conf.OTLP.QueueSize),
+	}
+
+	for _, header := range conf.OTLP.Headers {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+
+		headerName, headerValue, found := strings.Cut(header, "=")
+		if !found || strings.TrimSpace(headerName) == "" {
+			return nil, fmt.Errorf(`invalid OTLP header "%v", expected key=value`, headerName)
+		}
+		e.headers[strings.TrimSpace(headerName)] = strings.TrimSpace(headerValue)
+	}
+
+	switch conf.OTLP.Protocol {
+	case ProtocolGrpc:
+		if err := e.initGrpcClient(); err != nil {
+			return nil, err
+		}
+	default:
+		if err := e.initHttpClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	e.initMetrics()
+
+	go e.run()
+
+	return e, nil
+}
+
+func (e *Exporter) initHttpClient() error {
+	parsedUrl, err := url.Parse(e.conf.OTLP.Endpoint)
+	if err != nil {
+		return fmt.Errorf(`invalid OTLP endpoint: %w`, err)
+	}
+
+	switch parsedUrl.Scheme {
+	case "http", "https":
+	default:
+		return fmt.Errorf(`OTLP endpoint "%v" has unsupported scheme "%v" (http/protobuf needs full url, eg. http://otel-collector:4318/v1/metrics)`, parsedUrl.Redacted(), parsedUrl.Scheme)
+	}
+
+	e.httpClient = &http.Client{Timeout: e.conf.OTLP.Timeout}
+	return nil
+}
+
+func (e *Exporter) initGrpcClient() error {
+	target := e.conf.OTLP.Endpoint
+	transportCredentials := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if e.conf.OTLP.Insecure {
+		transportCredentials = insecure.NewCredentials()
+	}
+
+	// endpoint can also be set as url (http:// disables TLS)
+	if strings.Contains(target, "://") {
+		parsedUrl, err := url.Parse(target)
+		if err != nil {
+			return fmt.Errorf(`invalid OTLP endpoint: %w`, err)
+		}
+
+		if parsedUrl.Scheme == "http" {
+			transportCredentials = insecure.NewCredentials()
+		}
+		target = parsedUrl.Host
+	}
+
+	conn, err := grpc.NewClient(
+		target,
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithUserAgent(e.userAgent),
+	)
+	if err != nil {
+		return fmt.Errorf(`unable to create OTLP grpc client: %w`, err)
+	}
+
+	e.grpcClient = colmetricspb.NewMetricsServiceClient(conn)
+	return nil
+}
+
+func (e *Exporter) initMetrics() {
+	e.prometheus.queueLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_otlp_queue_length",
+			Help: "Azure metrics OTLP export queued requests",
+		},
+	)
+	prometheus.MustRegister(e.prometheus.queueLength)
+
+	e.prometheus.datapoints = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_otlp_datapoints",
+			Help: "Azure metrics OTLP export datapoints by result (success, failed, dropped)",
+		},
+		[]string{"result"},
+	)
+	prometheus.MustRegister(e.prometheus.datapoints)
+
+	e.prometheus.requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_otlp_requests",
+			Help: "Azure metrics OTLP export requests by result (success, error)",
+		},
+		[]string{"result"},
+	)
+	prometheus.MustRegister(e.prometheus.requests)
+}
+
+// Export queues a collected metric list for export (request is dropped if the queue is full)
+func (e *Exporter) Export(metricList *metrics.MetricList) {
+	if e == nil || metricList == nil {
+		return
+	}
+
+	request, datapoints := buildExportRequest(metricList, time.Now(), e.scopeVersion)
+	if datapoints == 0 {
+		return
+	}
+
+	select {
+	case e.queue <- &exportRequest{request: request, datapoints: datapoints}:
+		e.prometheus.queueLength.Set(float64(len(e.queue)))
+	default:
+		e.prometheus.datapoints.WithLabelValues("dropped").Add(float64(datapoints))
+		e.logger.Warn("OTLP export queue is full, dropping datapoints", slog.Int("datapoints", datapoints))
+	}
+}
+
+// run sends the queued export requests
+func (e *Exporter) run() {
+	for request := range e.queue {
+		e.prometheus.queueLength.Set(float64(len(e.queue)))
+
+		if err := e.sendWithRetry(request); err == nil {
+			e.prometheus.datapoints.WithLabelValues("success").Add(float64(request.datapoints))
+		} else {
+			e.prometheus.datapoints.WithLabelValues("failed").Add(float64(request.datapoints))
+			e.logger.Error("OTLP export failed", slog.Any("error", err.Error()))
+		}
+	}
+}
+
+// sendWithRetry sends an export request and retries retryable errors with exponential backoff
+func (e *Exporter) sendWithRetry(request *exportRequest) error {
+	backoff := minBackoff
+	for retry := 0; ; retry++ {
+		retryable, err := e.send(request.request)
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		e.prometheus.requests.WithLabelValues(result).Inc()
+
+		if err == nil {
+			return nil
+		}
+
+		if !retryable || retry >= e.conf.OTLP.MaxRetries {
+			return err
+		}
+
+		e.logger.Debug("retrying OTLP export", slog.Duration("backoff", backoff), slog.Any("error", err.Error()))
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (e *Exporter) send(request *colmetricspb.ExportMetricsServiceRequest) (retryable bool, err error) {
+	if e.grpcClient != nil {
+		return e.sendGrpc(request)
+	}
+	return e.sendHttp(request)
+}
+
+func (e *Exporter) sendGrpc(request *colmetricspb.ExportMetricsServiceRequest) (retryable bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.conf.OTLP.Timeout)
+	defer cancel()
+
+	if len(e.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.headers))
+	}
+
+	response, err := e.grpcClient.Export(ctx, request)
+	if err != nil {
+		// retryable status codes defined by OTLP specification
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+			return true, err
+		}
+		return false, err
+	}
+
+	e.logPartialSuccess(response)
+	return false, nil
+}
+
+func (e *Exporter) sendHttp(request *colmetricspb.ExportMetricsServiceRequest) (retryable bool, err error) {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.conf.OTLP.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", e.userAgent)
+	for headerName, headerValue := range e.headers {
+		req.Header.Set(headerName, headerValue)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode/100 == 2 {
+		response := &colmetricspb.ExportMetricsServiceResponse{}
+		if responseBody, err := io.ReadAll(resp.Body); err == nil && proto.Unmarshal(responseBody, response) == nil {
+			e.logPartialSuccess(response)
+		}
+		return false, nil
+	}
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	err = fmt.Errorf("server returned HTTP status %v: %v", resp.Status, strings.TrimSpace(string(responseBody)))
+
+	// retryable status codes defined by OTLP specification
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, err
+	}
+	return false, err
+}
+
+// logPartialSuccess logs datapoints rejected by the OTLP receiver (not retryable)
+func (e *Exporter) logPartialSuccess(response *colmetricspb.ExportMetricsServiceResponse) {
+	if partialSuccess := response.GetPartialSuccess(); partialSuccess != nil && partialSuccess.GetRejectedDataPoints() > 0 {
+		e.logger.Warn(
+			"OTLP endpoint rejected datapoints",
+			slog.Int64("rejected", partialSuccess.GetRejectedDataPoints()),
+			slog.String("message", partialSuccess.GetErrorMessage()),
+		)
+	}
+}
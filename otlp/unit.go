@@ -0,0 +1,45 @@
+package otlp
+
+import (
+	"strings"
+)
+
+var (
+	// Azure Monitor units and normalized units (normalizeUnits=true) as UCUM units (https://ucum.org/ucum),
+	// as expected by OTLP (eg. Seconds and seconds are both "s")
+	ucumUnits = map[string]string{
+		// Azure Monitor units
+		"bytes":          "By",
+		"bytespersecond": "By/s",
+		"bitspersecond":  "bit/s",
+		"byteseconds":    "By.s",
+		"seconds":        "s",
+		"milliseconds":   "ms",
+		"percent":        "%",
+		"count":          "1",
+		"countpersecond": "1/s",
+		"cores":          "{cores}",
+		"millicores":     "m{cores}",
+		"nanocores":      "n{cores}",
+		"unspecified":    "",
+
+		// normalized units
+		"bytes_per_second": "By/s",
+		"byte_seconds":     "By.s",
+		"ratio":            "1",
+		"per_second":       "1/s",
+	}
+)
+
+// ucumUnit returns the UCUM unit of an Azure Monitor or normalized unit,
+// unknown units are used as annotation (eg. {unknown})
+func ucumUnit(unit string) string {
+	if unit == "" {
+		return ""
+	}
+
+	if val, exists := ucumUnits[strings.ToLower(unit)]; exists {
+		return val
+	}
+	return "{" + unit + "}"
+}
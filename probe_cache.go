@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/cache"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+var (
+	// running background refreshes of stale cache entries (by cache key)
+	metricsCacheRefresh sync.Map
+)
+
+// countMetricRequests counts the probe targets of a cached or coalesced result in azurerm_stats_metric_requests
+// (per target, same as success and error of collected results)
+func countMetricRequests(handler, filter, result string, metricList *metrics.MetricList) {
+	if metricList == nil {
+		return
+	}
+
+	for _, subscriptionId := range metricList.GetProbeTargetSubscriptions() {
+		prometheusMetricRequests.With(prometheus.Labels{
+			"subscriptionID": subscriptionId,
+			"handler":        handler,
+			"filter":         filter,
+			"result":         result,
+		}).Inc()
+	}
+}
+
+// observeMetricsCache records metrics cache stats and triggers background refresh of stale cache entries
+func observeMetricsCache(r *http.Request, prober *metrics.MetricProber) {
+	status, age := prober.MetricsCacheStatus()
+	if status == "" {
+		// cache not enabled
+		return
+	}
+
+	prometheusMetricsCacheRequests.With(prometheus.Labels{
+		"handler": r.URL.Path,
+		"result":  status,
+	}).Inc()
+
+	if status == metrics.MetricsCacheStatusMiss {
+		return
+	}
+
+	prometheusMetricsCacheAge.With(prometheus.Labels{
+		"handler": r.URL.Path,
+	}).Observe(age.Seconds())
+
+	if status == metrics.MetricsCacheStatusStale {
+		refreshMetricsCache(r.URL.Path, r.URL.Query(), prober.MetricsCacheKey())
+	}
+}
+
+// refreshMetricsCache collects metrics in background and updates the cache entry (only one refresh per cache key)
+func refreshMetricsCache(endpoint string, params url.Values, cacheKey string) {
+	module, exists := probeModules[endpoint]
+	if !exists {
+		return
+	}
+
+	if _, running := metricsCacheRefresh.LoadOrStore(cacheKey, true); running {
+		return
+	}
+
+	go func() {
+		defer metricsCacheRefresh.Delete(cacheKey)
+
+		// shared cache backend: entry is already refreshed by another exporter instance
+		unlock, ok := cache.TryLock(metricsCache, cacheKey, module.timeout)
+		if !ok {
+			return
+		}
+		defer unlock()
+
+		startTime := time.Now()
+		contextLogger := logger.With(slog.String("handler", endpoint), slog.String("cacheKey", cacheKey))
+		contextLogger.Debug("refreshing stale cache entry")
+
+		ctx, cancel := context.WithTimeout(context.Background(), module.timeout)
+		defer cancel()
+
+		result := "success"
+		prober, settings, err := collectProbeModule(ctx, contextLogger.Logger, endpoint, params)
+		if err == nil {
+			prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
+			prober.SaveToCache()
+		} else {
+			result = "error"
+			contextLogger.Error("cache refresh failed", slog.Any("error", err.Error()))
+		}
+
+		prometheusMetricsCacheRefresh.With(prometheus.Labels{
+			"handler": endpoint,
+			"result":  result,
+		}).Inc()
+	}()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	ProbeFormatPrometheus = ""
+	ProbeFormatJson       = "json"
+	ProbeFormatCsv        = "csv"
+
+	probeCsvFixedColumns = 5
+)
+
+type (
+	// probeMetricRow is one metric row of the json and csv output formats
+	probeMetricRow struct {
+		Name      string            `json:"name"`
+		Type      string            `json:"type"`
+		Unit      string            `json:"unit,omitempty"`
+		Labels    map[string]string `json:"labels"`
+		Value     metrics.JsonFloat `json:"value"`
+		Timestamp *time.Time        `json:"timestamp,omitempty"`
+	}
+)
+
+// probeFormat returns the requested output format of a probe (parameter format)
+func probeFormat(params url.Values) (string, error) {
+	format := params.Get("format")
+	switch format {
+	case ProbeFormatPrometheus, ProbeFormatJson, ProbeFormatCsv:
+		return format, nil
+	}
+	return "", fmt.Errorf(`parameter "format" has invalid value "%v"`, format)
+}
+
+// probeCacheKey returns the metrics cache key of a probe request, independent of the output format
+// (all formats share one cache entry, parameters are sorted)
+func probeCacheKey(prefix string, r *http.Request) string {
+	requestUrl := *r.URL
+	params := requestUrl.Query()
+	params.Del("format")
+	requestUrl.RawQuery = params.Encode()
+	return fmt.Sprintf("%v:%x", prefix, sha256.Sum256([]byte(requestUrl.String())))
+}
+
+// probeMetricsHandler serves the probe result in the requested format (Prometheus metrics, json or csv)
+func probeMetricsHandler(registry *prometheus.Registry, metricList *metrics.MetricList) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("format") {
+		case ProbeFormatJson:
+			writeMetricListJson(w, metricList)
+		case ProbeFormatCsv:
+			writeMetricListCsv(w, metricList)
+		default:
+			prometheusMetricsHandler(registry, metricList).ServeHTTP(w, r)
+		}
+	})
+}
+
+// prometheusMetricsHandler serves the probe registry, with --metrics.openmetrics the format is negotiated
+// (including OpenMetrics) and the unit of the published metrics is added as metadata (# UNIT)
+func prometheusMetricsHandler(registry *prometheus.Registry, metricList *metrics.MetricList) http.Handler {
+	if !Opts.Metrics.OpenMetrics {
+		return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
+
+	units := map[string]string{}
+	if metricList != nil {
+		units = metricList.GetMetricUnits()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricFamilies, err := registry.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		w.Header().Set("Content-Type", string(format))
+
+		encoder := expfmt.NewEncoder(w, format, expfmt.WithUnit())
+		for _, metricFamily := range metricFamilies {
+			if unit, exists := units[metricFamily.GetName()]; exists {
+				metricFamily.Unit = &unit
+			}
+
+			if err := encoder.Encode(metricFamily); err != nil {
+				logger.Error("unable to encode metrics", slog.Any("error", err.Error()))
+				return
+			}
+		}
+
+		if closer, ok := encoder.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Error("unable to encode metrics", slog.Any("error", err.Error()))
+			}
+		}
+	})
+}
+
+// probeMetricRows returns the rows of a metric list sorted by metric name (rows of a metric keep their order)
+func probeMetricRows(metricList *metrics.MetricList) []probeMetricRow {
+	rows := []probeMetricRow{}
+	if metricList == nil {
+		return rows
+	}
+
+	metricNames := metricList.GetMetricNames()
+	sort.Strings(metricNames)
+
+	for _, metricName := range metricNames {
+		metricType := "gauge"
+		if metricList.GetMetricValueType(metricName) == prometheus.CounterValue {
+			metricType = "counter"
+		}
+
+		for _, row := range metricList.GetMetricList(metricName) {
+			rows = append(rows, probeMetricRow{
+				Name:      metricName,
+				Type:      metricType,
+				Unit:      row.AzureUnit,
+				Labels:    row.Labels,
+				Value:     metrics.JsonFloat(row.Value),
+				Timestamp: row.Timestamp,
+			})
+		}
+	}
+
+	return rows
+}
+
+// writeMetricListJson writes the metric rows as json list
+func writeMetricListJson(w http.ResponseWriter, metricList *metrics.MetricList) {
+	data, err := json.Marshal(probeMetricRows(metricList))
+	if err != nil {
+		logger.Error("unable to encode metrics", slog.Any("error", err.Error()))
+		http.Error(w, "unable to encode metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		logger.Error("unable to write metrics", slog.Any("error", err.Error()))
+	}
+}
+
+// writeMetricListCsv writes the metric rows as csv (one column per label, missing labels are empty)
+func writeMetricListCsv(w http.ResponseWriter, metricList *metrics.MetricList) {
+	rows := probeMetricRows(metricList)
+
+	labelNameMap := map[string]bool{}
+	for _, row := range rows {
+		for labelName := range row.Labels {
+			labelNameMap[labelName] = true
+		}
+	}
+
+	labelNames := make([]string, 0, len(labelNameMap))
+	for labelName := range labelNameMap {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+
+	header := append([]string{"name", "type", "unit", "timestamp", "value"}, labelNames...)
+	if err := writer.Write(header); err != nil {
+		logger.Error("unable to encode metrics", slog.Any("error", err.Error()))
+		return
+	}
+
+	for _, row := range rows {
+		record := make([]string, probeCsvFixedColumns, probeCsvFixedColumns+len(labelNames))
+		record[0] = row.Name
+		record[1] = row.Type
+		record[2] = row.Unit
+		if row.Timestamp != nil {
+			record[3] = row.Timestamp.UTC().Format(time.RFC3339)
+		}
+		record[4] = strconv.FormatFloat(float64(row.Value), 'g', -1, 64)
+
+		for _, labelName := range labelNames {
+			record = append(record, row.Labels[labelName])
+		}
+
+		if err := writer.Write(record); err != nil {
+			logger.Error("unable to encode metrics", slog.Any("error", err.Error()))
+			return
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logger.Error("unable to encode metrics", slog.Any("error", err.Error()))
+	}
+}
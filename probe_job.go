@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	probeModule struct {
+		// http handler of the probe endpoint
+		handler http.HandlerFunc
+
+		// builds request settings
+		settings func(r *http.Request, opts config.Opts) (metrics.RequestMetricSettings, error)
+
+		// adds targets to prober (not used on subscription scope)
+		discovery func(ctx context.Context, prober *metrics.MetricProber, settings *metrics.RequestMetricSettings, params url.Values) error
+
+		subscriptionScope bool
+
+		// timeout for collection without http request (eg. background refresh)
+		timeout time.Duration
+	}
+)
+
+const (
+	// query parameter of the job name (parameter name is the metric name of the job settings)
+	ProbeJobParamName = "job"
+)
+
+var (
+	probeModules map[string]probeModule
+)
+
+func init() {
+	// initialized in init() as probe handlers trigger background cache refreshes using probeModules
+	probeModules = map[string]probeModule{
+		config.ProbeMetricsResourceUrl: {
+			handler:   probeMetricsResourceHandler,
+			settings:  metrics.NewRequestMetricSettingsForAzureResourceApi,
+			discovery: probeMetricsResourceDiscovery,
+			timeout:   config.ProbeMetricsResourceTimeoutDefault * time.Second,
+		},
+		config.ProbeMetricsListUrl: {
+			handler:   probeMetricsListHandler,
+			settings:  metrics.NewRequestMetricSettingsForAzureResourceApi,
+			discovery: probeMetricsListDiscovery,
+			timeout:   config.ProbeMetricsListTimeoutDefault * time.Second,
+		},
+		config.ProbeMetricsSubscriptionUrl: {
+			handler:           probeMetricsSubscriptionHandler,
+			settings:          metrics.NewRequestMetricSettingsForAzureResourceApi,
+			subscriptionScope: true,
+			timeout:           config.ProbeMetricsSubscriptionTimeoutDefault * time.Second,
+		},
+		config.ProbeMetricsScrapeUrl: {
+			handler:   probeMetricsScrapeHandler,
+			settings:  metrics.NewRequestMetricSettingsForAzureResourceApi,
+			discovery: probeMetricsScrapeDiscovery,
+			timeout:   config.ProbeMetricsScrapeTimeoutDefault * time.Second,
+		},
+		config.ProbeMetricsResourceGraphUrl: {
+			handler:   probeMetricsResourceGraphHandler,
+			settings:  metrics.NewRequestMetricSettings,
+			discovery: probeMetricsResourceGraphDiscovery,
+			timeout:   config.ProbeMetricsResourceGraphTimeoutDefault * time.Second,
+		},
+	}
+}
+
+func probeJobHandler(w http.ResponseWriter, r *http.Request) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	jobName, err := paramsGetRequired(r.URL.Query(), ProbeJobParamName)
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := Config.GetJob(jobName)
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	module, exists := probeModules[job.Endpoint]
+	if !exists {
+		contextLogger.Error("unsupported job endpoint")
+		http.Error(w, "unsupported job endpoint", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = probeFormat(r.URL.Query()); err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// serve result of background collection (only without overrides, output format is not an override)
+	overrides := r.URL.Query()
+	overrides.Del(ProbeJobParamName)
+	overrides.Del("format")
+	if job.IsScheduled() && len(overrides) == 0 {
+		registry := prometheus.NewRegistry()
+		metricList, collected := scheduler.GetMetricList(jobName)
+		if collected {
+			metricList.Publish(registry)
+			w.Header().Add("X-metrics-scheduled", "true")
+		} else {
+			w.Header().Add("X-metrics-scheduled", "pending")
+		}
+
+		h := probeMetricsHandler(registry, metricList)
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	// job settings with query parameters as override (except job name)
+	params := job.Values()
+	for name, value := range r.URL.Query() {
+		if name == ProbeJobParamName {
+			continue
+		}
+		params[name] = value
+	}
+
+	jobRequest := r.Clone(r.Context())
+	jobRequest.URL.Path = job.Endpoint
+	jobRequest.URL.RawQuery = params.Encode()
+
+	module.handler(w, jobRequest)
+}
+
+// collectProbeModule runs service discovery and metric collection of a probe endpoint without http response
+// (eg. background collection or cache refresh)
+func collectProbeModule(ctx context.Context, contextLogger *slog.Logger, endpoint string, params url.Values) (*metrics.MetricProber, *metrics.RequestMetricSettings, error) {
+	module, exists := probeModules[endpoint]
+	if !exists {
+		return nil, nil, fmt.Errorf(`unsupported probe endpoint "%v"`, endpoint)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings, err := module.settings(r, Opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prober := metrics.NewMetricProber(ctx, contextLogger, nil, &settings, Opts)
+	prober.SetUserAgent(UserAgent + gitTag)
+	prober.SetAzureClient(AzureClient)
+	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	registerMetricListCollectedCallbacks(prober)
+	prober.SetServiceDiscoveryExcludedCounter(prometheusServiceDiscoveryExcluded.MustCurryWith(prometheus.Labels{"handler": endpoint}))
+
+	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
+		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
+	}
+
+	if err := prober.ResolveSubscriptions(); err != nil {
+		return nil, nil, err
+	}
+
+	if module.subscriptionScope {
+		prober.CollectOnSubscriptionScope()
+	} else {
+		if err := module.discovery(ctx, prober, &settings, params); err != nil {
+			return nil, nil, err
+		}
+		prober.Collect()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return prober, &settings, nil
+}
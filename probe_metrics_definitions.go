@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeMetricsDefinitionsHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeMetricsDefinitionsTimeoutDefault)
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	params := r.URL.Query()
+
+	// static targets or service discovery by resourceType/filter
+	var settings metrics.RequestMetricSettings
+	if params.Get("target") != "" {
+		settings, err = metrics.NewRequestMetricSettings(r, Opts)
+	} else {
+		settings, err = metrics.NewRequestMetricSettingsForAzureResourceApi(r, Opts)
+	}
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := probeFormat(params)
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prober := metrics.NewMetricProber(ctx, contextLogger.Logger, w, &settings, Opts)
+	prober.SetUserAgent(UserAgent + gitTag)
+	prober.SetAzureClient(AzureClient)
+	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	prober.SetServiceDiscoveryExcludedCounter(prometheusServiceDiscoveryExcluded.MustCurryWith(prometheus.Labels{"handler": r.URL.Path}))
+
+	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
+		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
+	}
+
+	if params.Get("target") != "" {
+		err = probeMetricsResourceDiscovery(ctx, prober, &settings, params)
+	} else if err = prober.ResolveSubscriptions(); err == nil {
+		// subscription=* and managementGroup are expanded to subscriptions before service discovery
+		err = probeMetricsListDiscovery(ctx, prober, &settings, params)
+	}
+	if err != nil {
+		contextLogger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	definitionList, err := prober.FetchMetricDefinitions()
+	if err != nil {
+		contextLogger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	switch format {
+	case ProbeFormatJson:
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(definitionList); err != nil {
+			contextLogger.Error(err.Error())
+		}
+	case ProbeFormatCsv:
+		writeMetricDefinitionsCsv(w, contextLogger.Logger, definitionList)
+	default:
+		definitionInfo := prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "azurerm_metric_definition_info",
+				Help: "Azure Monitor metric definition",
+			},
+			[]string{
+				"resourceType",
+				"metricNamespace",
+				"metric",
+				"displayName",
+				"unit",
+				"primaryAggregation",
+				"aggregations",
+				"timeGrains",
+				"dimensions",
+				"dimensionRequired",
+			},
+		)
+		registry.MustRegister(definitionInfo)
+
+		for _, definition := range definitionList {
+			definitionInfo.With(prometheus.Labels{
+				"resourceType":       strings.ToLower(definition.ResourceType),
+				"metricNamespace":    definition.Namespace,
+				"metric":             definition.Name,
+				"displayName":        definition.DisplayName,
+				"unit":               definition.Unit,
+				"primaryAggregation": definition.PrimaryAggregation,
+				"aggregations":       strings.Join(definition.SupportedAggregations, ","),
+				"timeGrains":         strings.Join(definition.TimeGrains, ","),
+				"dimensions":         strings.Join(definition.Dimensions, ","),
+				"dimensionRequired":  fmt.Sprintf("%t", definition.IsDimensionRequired),
+			}).Set(1)
+		}
+
+		h := probeMetricsHandler(registry, nil)
+		h.ServeHTTP(w, r)
+	}
+
+	latency := time.Since(startTime)
+	contextLogger.With(
+		slog.String("method", r.Method),
+		slog.Int("status", http.StatusOK),
+		slog.Duration("latency", latency),
+	).Debug("request handled")
+}
+
+// writeMetricDefinitionsCsv writes the metric definitions as csv (lists are comma separated)
+func writeMetricDefinitionsCsv(w http.ResponseWriter, contextLogger *slog.Logger, definitionList []metrics.MetricDefinition) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+
+	records := [][]string{{
+		"resourceType",
+		"namespace",
+		"name",
+		"displayName",
+		"unit",
+		"primaryAggregation",
+		"supportedAggregations",
+		"timeGrains",
+		"dimensions",
+		"isDimensionRequired",
+	}}
+	for _, definition := range definitionList {
+		records = append(records, []string{
+			strings.ToLower(definition.ResourceType),
+			definition.Namespace,
+			definition.Name,
+			definition.DisplayName,
+			definition.Unit,
+			definition.PrimaryAggregation,
+			strings.Join(definition.SupportedAggregations, ","),
+			strings.Join(definition.TimeGrains, ","),
+			strings.Join(definition.Dimensions, ","),
+			strconv.FormatBool(definition.IsDimensionRequired),
+		})
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		contextLogger.Error(err.Error())
+	}
+}
@@ -2,14 +2,13 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -48,30 +47,25 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err = probeFormat(r.URL.Query()); err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	prober := metrics.NewMetricProber(ctx, contextLogger.Logger, w, &settings, Opts)
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
 	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	registerMetricListCollectedCallbacks(prober)
 	prober.SetPrometheusRegistry(registry)
+	cacheKey := probeCacheKey("resource", r)
 	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("resource:%x", sha256.Sum256([]byte(r.URL.String())))
 		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
 	}
+	prober.EnableRequestCoalescing(probeCoalescer, cacheKey)
 
-	if resourceList, err := paramsGetListRequired(r.URL.Query(), "target"); err == nil {
-		targetList := []metrics.MetricProbeTarget{}
-		for _, resourceId := range resourceList {
-			targetList = append(
-				targetList,
-				metrics.MetricProbeTarget{
-					ResourceId:   resourceId,
-					Metrics:      settings.Metrics,
-					Aggregations: settings.Aggregations,
-				},
-			)
-		}
-		prober.AddTarget(targetList...)
-	} else {
+	if err := probeMetricsResourceDiscovery(ctx, prober, &settings, r.URL.Query()); err != nil {
 		contextLogger.Error(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -82,25 +76,45 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsResourceUrl,
 				"filter":         settings.Filter,
 			}).Observe(time.Since(startTime).Seconds())
 		})
 
-		prober.Run()
-	} else {
-		w.Header().Add("X-metrics-cached", "true")
-		for _, subscriptionId := range settings.Subscriptions {
+		prober.RegisterTargetCollectFinishCallback(func(subscriptionId string, err error) {
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+
+			// global stats counter
 			prometheusMetricRequests.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsResourceUrl,
 				"filter":         settings.Filter,
-				"result":         "cached",
+				"result":         result,
 			}).Inc()
+		})
+
+		coalesced, err := prober.RunCoalesced(nil)
+		if err != nil {
+			contextLogger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+
+		if coalesced {
+			w.Header().Add("X-metrics-coalesced", "true")
+			countMetricRequests(config.ProbeMetricsResourceUrl, settings.Filter, "coalesced", prober.GetMetricList())
+		}
+	} else {
+		w.Header().Add("X-metrics-cached", "true")
+		countMetricRequests(config.ProbeMetricsResourceUrl, settings.Filter, "cached", prober.GetMetricList())
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	observeMetricsCache(r, prober)
+
+	h := probeMetricsHandler(registry, prober.GetMetricList())
 	h.ServeHTTP(w, r)
 
 	latency := time.Since(startTime)
@@ -110,3 +124,25 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 		slog.Duration("latency", latency),
 	).Debug("request handled")
 }
+
+func probeMetricsResourceDiscovery(ctx context.Context, prober *metrics.MetricProber, settings *metrics.RequestMetricSettings, params url.Values) error {
+	resourceList, err := paramsGetListRequired(params, "target")
+	if err != nil {
+		return err
+	}
+
+	targetList := []metrics.MetricProbeTarget{}
+	for _, resourceId := range resourceList {
+		targetList = append(
+			targetList,
+			metrics.MetricProbeTarget{
+				ResourceId:   resourceId,
+				Metrics:      settings.Metrics,
+				Aggregations: settings.Aggregations,
+			},
+		)
+	}
+	prober.AddTarget(targetList...)
+
+	return nil
+}
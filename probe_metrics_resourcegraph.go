@@ -2,14 +2,13 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -42,14 +41,19 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = paramsGetListRequired(r.URL.Query(), "subscription"); err != nil {
+	if query, err := resourceGraphQuery(r.URL.Query()); err != nil {
 		contextLogger.Warn(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
+	} else if query == "" {
+		if _, err = paramsGetRequired(r.URL.Query(), "resourceType"); err != nil {
+			contextLogger.Warn(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	resourceType, err := paramsGetRequired(r.URL.Query(), "resourceType")
-	if err != nil {
+	if _, err = probeFormat(r.URL.Query()); err != nil {
 		contextLogger.Warn(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -59,47 +63,65 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
 	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	registerMetricListCollectedCallbacks(prober)
+	prober.SetServiceDiscoveryExcludedCounter(prometheusServiceDiscoveryExcluded.MustCurryWith(prometheus.Labels{"handler": r.URL.Path}))
 	prober.SetPrometheusRegistry(registry)
+	cacheKey := probeCacheKey("scrape", r)
 	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("scrape:%x", sha256.Sum256([]byte(r.URL.String())))
 		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
 	}
+	prober.EnableRequestCoalescing(probeCoalescer, cacheKey)
 
 	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
 		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
 	}
 
 	if !prober.FetchFromCache() {
-		err := prober.ServiceDiscovery.FindResourceGraph(ctx, settings.Subscriptions, resourceType, settings.Filter)
-		if err != nil {
-			contextLogger.Error(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
 		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsResourceGraphUrl,
 				"filter":         settings.Filter,
 			}).Observe(time.Since(startTime).Seconds())
 		})
 
-		prober.Run()
-	} else {
-		w.Header().Add("X-metrics-cached", "true")
-		for _, subscriptionId := range settings.Subscriptions {
+		prober.RegisterTargetCollectFinishCallback(func(subscriptionId string, err error) {
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+
+			// global stats counter
 			prometheusMetricRequests.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsResourceGraphUrl,
 				"filter":         settings.Filter,
-				"result":         "cached",
+				"result":         result,
 			}).Inc()
+		})
+
+		coalesced, err := prober.RunCoalesced(func() error {
+			return probeMetricsResourceGraphDiscovery(ctx, prober, &settings, r.URL.Query())
+		})
+		if err != nil {
+			contextLogger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if coalesced {
+			w.Header().Add("X-metrics-coalesced", "true")
+			countMetricRequests(config.ProbeMetricsResourceGraphUrl, settings.Filter, "coalesced", prober.GetMetricList())
 		}
+	} else {
+		w.Header().Add("X-metrics-cached", "true")
+		countMetricRequests(config.ProbeMetricsResourceGraphUrl, settings.Filter, "cached", prober.GetMetricList())
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	observeMetricsCache(r, prober)
+
+	h := probeMetricsHandler(registry, prober.GetMetricList())
 	h.ServeHTTP(w, r)
 
 	latency := time.Since(startTime)
@@ -109,3 +131,44 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 		slog.Duration("latency", latency),
 	).Debug("request handled")
 }
+
+func probeMetricsResourceGraphDiscovery(ctx context.Context, prober *metrics.MetricProber, settings *metrics.RequestMetricSettings, params url.Values) error {
+	query, err := resourceGraphQuery(params)
+	if err != nil {
+		return err
+	}
+
+	if query != "" {
+		return prober.ServiceDiscovery.FindResourceGraphQuery(ctx, settings.Subscriptions, query)
+	}
+
+	resourceType, err := paramsGetRequired(params, "resourceType")
+	if err != nil {
+		return err
+	}
+
+	return prober.ServiceDiscovery.FindResourceGraph(ctx, settings.Subscriptions, resourceType, settings.Filter)
+}
+
+// resourceGraphQuery returns the custom Kusto query (parameter query or named query from config file by parameter queryName),
+// empty if resourceType and filter should be used
+func resourceGraphQuery(params url.Values) (string, error) {
+	query := params.Get("query")
+
+	if queryName := params.Get("queryName"); queryName != "" {
+		if query != "" {
+			return "", fmt.Errorf(`parameter "query" and "queryName" are mutually exclusive`)
+		}
+
+		var err error
+		if query, err = Config.GetQuery(queryName); err != nil {
+			return "", err
+		}
+	}
+
+	if query != "" && (params.Get("resourceType") != "" || params.Get("filter") != "") {
+		return "", fmt.Errorf(`parameter "query" (or "queryName") can't be used together with "resourceType" or "filter"`)
+	}
+
+	return query, nil
+}
@@ -2,14 +2,13 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -18,7 +17,6 @@ import (
 func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var timeoutSeconds float64
-	var metricTagName, aggregationTagName string
 
 	startTime := time.Now()
 	contextLogger := buildContextLoggerFromRequest(r)
@@ -43,18 +41,18 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = paramsGetListRequired(r.URL.Query(), "subscription"); err != nil {
+	if _, err = paramsGetRequired(r.URL.Query(), "metricTagName"); err != nil {
 		contextLogger.Warn(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if metricTagName, err = paramsGetRequired(r.URL.Query(), "metricTagName"); err != nil {
+	if _, err = paramsGetRequired(r.URL.Query(), "aggregationTagName"); err != nil {
 		contextLogger.Warn(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if aggregationTagName, err = paramsGetRequired(r.URL.Query(), "aggregationTagName"); err != nil {
+
+	if _, err = probeFormat(r.URL.Query()); err != nil {
 		contextLogger.Warn(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -64,44 +62,65 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
 	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	registerMetricListCollectedCallbacks(prober)
+	prober.SetServiceDiscoveryExcludedCounter(prometheusServiceDiscoveryExcluded.MustCurryWith(prometheus.Labels{"handler": r.URL.Path}))
 	prober.SetPrometheusRegistry(registry)
+	cacheKey := probeCacheKey("scrape", r)
 	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("scrape:%x", sha256.Sum256([]byte(r.URL.String())))
 		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
 	}
+	prober.EnableRequestCoalescing(probeCoalescer, cacheKey)
 
 	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
 		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
 	}
 
 	if !prober.FetchFromCache() {
-		for _, subscription := range settings.Subscriptions {
-			prober.ServiceDiscovery.FindSubscriptionResourcesWithScrapeTags(ctx, subscription, settings.Filter, metricTagName, aggregationTagName)
-		}
-
 		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsScrapeUrl,
 				"filter":         settings.Filter,
 			}).Observe(time.Since(startTime).Seconds())
 		})
 
-		prober.Run()
-	} else {
-		w.Header().Add("X-metrics-cached", "true")
-		for _, subscriptionId := range settings.Subscriptions {
+		prober.RegisterTargetCollectFinishCallback(func(subscriptionId string, err error) {
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+
+			// global stats counter
 			prometheusMetricRequests.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsScrapeUrl,
 				"filter":         settings.Filter,
-				"result":         "cached",
+				"result":         result,
 			}).Inc()
+		})
+
+		coalesced, err := prober.RunCoalesced(func() error {
+			return probeMetricsScrapeDiscovery(ctx, prober, &settings, r.URL.Query())
+		})
+		if err != nil {
+			contextLogger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if coalesced {
+			w.Header().Add("X-metrics-coalesced", "true")
+			countMetricRequests(config.ProbeMetricsScrapeUrl, settings.Filter, "coalesced", prober.GetMetricList())
 		}
+	} else {
+		w.Header().Add("X-metrics-cached", "true")
+		countMetricRequests(config.ProbeMetricsScrapeUrl, settings.Filter, "cached", prober.GetMetricList())
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	observeMetricsCache(r, prober)
+
+	h := probeMetricsHandler(registry, prober.GetMetricList())
 	h.ServeHTTP(w, r)
 
 	latency := time.Since(startTime)
@@ -111,3 +130,20 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 		slog.Duration("latency", latency),
 	).Debug("request handled")
 }
+
+func probeMetricsScrapeDiscovery(ctx context.Context, prober *metrics.MetricProber, settings *metrics.RequestMetricSettings, params url.Values) error {
+	metricTagName, err := paramsGetRequired(params, "metricTagName")
+	if err != nil {
+		return err
+	}
+
+	aggregationTagName, err := paramsGetRequired(params, "aggregationTagName")
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range settings.Subscriptions {
+		prober.ServiceDiscovery.FindSubscriptionResourcesWithScrapeTags(ctx, subscription, settings.Filter, metricTagName, aggregationTagName)
+	}
+	return nil
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -42,7 +40,7 @@ func probeMetricsSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = paramsGetListRequired(r.URL.Query(), "subscription"); err != nil {
+	if _, err = probeFormat(r.URL.Query()); err != nil {
 		contextLogger.Warn(err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -52,36 +50,58 @@ func probeMetricsSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
 	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	registerMetricListCollectedCallbacks(prober)
 	prober.SetPrometheusRegistry(registry)
+	cacheKey := probeCacheKey("list", r)
 	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("list:%x", sha256.Sum256([]byte(r.URL.String())))
 		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
 	}
+	prober.EnableRequestCoalescing(probeCoalescer, cacheKey)
 
 	if !prober.FetchFromCache() {
 		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsSubscriptionUrl,
 				"filter":         settings.Filter,
 			}).Observe(time.Since(startTime).Seconds())
 		})
 
-		prober.RunOnSubscriptionScope()
-	} else {
-		w.Header().Add("X-metrics-cached", "true")
-		for _, subscriptionId := range settings.Subscriptions {
+		prober.RegisterTargetCollectFinishCallback(func(subscriptionId string, err error) {
+			result := "success"
+			if err != nil {
+				result = "error"
+			}
+
+			// global stats counter
 			prometheusMetricRequests.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
-				"handler":        config.ProbeMetricsListUrl,
+				"handler":        config.ProbeMetricsSubscriptionUrl,
 				"filter":         settings.Filter,
-				"result":         "cached",
+				"result":         result,
 			}).Inc()
+		})
+
+		coalesced, err := prober.RunOnSubscriptionScopeCoalesced()
+		if err != nil {
+			contextLogger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+
+		if coalesced {
+			w.Header().Add("X-metrics-coalesced", "true")
+			countMetricRequests(config.ProbeMetricsSubscriptionUrl, settings.Filter, "coalesced", prober.GetMetricList())
+		}
+	} else {
+		w.Header().Add("X-metrics-cached", "true")
+		countMetricRequests(config.ProbeMetricsSubscriptionUrl, settings.Filter, "cached", prober.GetMetricList())
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	observeMetricsCache(r, prober)
+
+	h := probeMetricsHandler(registry, prober.GetMetricList())
 	h.ServeHTTP(w, r)
 
 	latency := time.Since(startTime)
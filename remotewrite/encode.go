@@ -0,0 +1,198 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+// field numbers of the Prometheus remote-write protobuf messages (prompb, remote-write 1.0)
+const (
+	writeRequestTimeseriesField = 1
+
+	timeseriesLabelsField  = 1
+	timeseriesSamplesField = 2
+
+	labelNameField  = 1
+	labelValueField = 2
+
+	sampleValueField     = 1
+	sampleTimestampField = 2
+
+	metricNameLabel = "__name__"
+)
+
+type (
+	// timeseries is one remote-write series with its samples (sorted by timestamp)
+	timeseries struct {
+		key     string
+		labels  []label
+		samples []sample
+	}
+
+	label struct {
+		name  string
+		value string
+	}
+
+	sample struct {
+		value     float64
+		timestamp int64
+	}
+)
+
+// buildTimeseries converts a metric list into remote-write series, rows of the same series (eg. datapoint=timestamp)
+// are grouped into one series with samples sorted by timestamp, rows without Azure timestamp use the collection time
+func buildTimeseries(metricList *metrics.MetricList, collectTime time.Time) (list []timeseries) {
+	seriesIndex := map[string]int{}
+
+	for _, metricName := range metricList.GetMetricNames() {
+		for _, row := range metricList.GetMetricList(metricName) {
+			labels := []label{{name: metricNameLabel, value: metricName}}
+
+			// empty labels are the same as missing labels in Prometheus
+			for labelName, labelValue := range row.Labels {
+				if labelValue != "" {
+					labels = append(labels, label{name: labelName, value: labelValue})
+				}
+			}
+
+			// remote-write requires sorted labels
+			sort.Slice(labels, func(i, j int) bool {
+				return labels[i].name < labels[j].name
+			})
+
+			timestamp := collectTime.UnixMilli()
+			if row.Timestamp != nil {
+				timestamp = row.Timestamp.UnixMilli()
+			}
+
+			key := seriesKey(labels)
+			if i, exists := seriesIndex[key]; exists {
+				list[i].samples = append(list[i].samples, sample{value: row.Value, timestamp: timestamp})
+				continue
+			}
+
+			seriesIndex[key] = len(list)
+			list = append(list, timeseries{
+				key:     key,
+				labels:  labels,
+				samples: []sample{{value: row.Value, timestamp: timestamp}},
+			})
+		}
+	}
+
+	for i := range list {
+		list[i].samples = sortSamples(list[i].samples)
+	}
+
+	return list
+}
+
+// sortSamples sorts samples by timestamp, for duplicate timestamps only the last sample is kept
+// (Prometheus rejects different values for the same timestamp)
+func sortSamples(samples []sample) []sample {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].timestamp < samples[j].timestamp
+	})
+
+	ret := samples[:0]
+	for _, s := range samples {
+		if len(ret) > 0 && ret[len(ret)-1].timestamp == s.timestamp {
+			ret[len(ret)-1] = s
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// seriesKey returns a unique key of the (sorted) series labels
+func seriesKey(labels []label) string {
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, l.name+"="+l.value)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// splitTimeseries splits series into batches of at most maxSamples samples
+// (series with more samples are split into multiple series, sent in timestamp order)
+func splitTimeseries(list []timeseries, maxSamples int) (batches [][]timeseries) {
+	var batch []timeseries
+	batchSamples := 0
+
+	for _, series := range list {
+		for len(series.samples) > 0 {
+			if batchSamples >= maxSamples {
+				batches = append(batches, batch)
+				batch, batchSamples = nil, 0
+			}
+
+			chunkSize := min(len(series.samples), maxSamples-batchSamples)
+			batch = append(batch, timeseries{
+				key:     series.key,
+				labels:  series.labels,
+				samples: series.samples[:chunkSize],
+			})
+			batchSamples += chunkSize
+			series.samples = series.samples[chunkSize:]
+		}
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// countSamples returns the number of samples of all series
+func countSamples(list []timeseries) (samples int) {
+	for _, series := range list {
+		samples += len(series.samples)
+	}
+	return samples
+}
+
+// encodeWriteRequest encodes series as protobuf WriteRequest
+func encodeWriteRequest(list []timeseries) []byte {
+	var buf []byte
+	for _, series := range list {
+		buf = protowire.AppendTag(buf, writeRequestTimeseriesField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, encodeTimeseries(series))
+	}
+	return buf
+}
+
+func encodeTimeseries(series timeseries) []byte {
+	var buf []byte
+	for _, l := range series.labels {
+		var labelBuf []byte
+		labelBuf = protowire.AppendTag(labelBuf, labelNameField, protowire.BytesType)
+		labelBuf = protowire.AppendString(labelBuf, l.name)
+		labelBuf = protowire.AppendTag(labelBuf, labelValueField, protowire.BytesType)
+		labelBuf = protowire.AppendString(labelBuf, l.value)
+
+		buf = protowire.AppendTag(buf, timeseriesLabelsField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, labelBuf)
+	}
+
+	for _, s := range series.samples {
+		var sampleBuf []byte
+		sampleBuf = protowire.AppendTag(sampleBuf, sampleValueField, protowire.Fixed64Type)
+		sampleBuf = protowire.AppendFixed64(sampleBuf, math.Float64bits(s.value))
+		sampleBuf = protowire.AppendTag(sampleBuf, sampleTimestampField, protowire.VarintType)
+		sampleBuf = protowire.AppendVarint(sampleBuf, uint64(s.timestamp)) // #nosec G115 -- protobuf int64 encoding
+
+		buf = protowire.AppendTag(buf, timeseriesSamplesField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, sampleBuf)
+	}
+
+	return buf
+}
@@ -0,0 +1,420 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	RemoteWriteVersion = "0.1.0"
+
+	// maximum length of response body used in error messages
+	errorBodyLimit = 512
+
+	// last pushed timestamps are removed if a series is not pushed anymore (eg. resource was deleted)
+	pushedSeriesExpiry = 6 * time.Hour
+)
+
+type (
+	// Writer pushes collected metric lists to Prometheus remote-write endpoints (one queue per endpoint)
+	Writer struct {
+		conf      config.Opts
+		userAgent string
+		logger    *slog.Logger
+		client    *http.Client
+		endpoints []*endpoint
+
+		// cancelled if queued requests couldn't be sent until shutdown deadline (aborts requests and retry backoff)
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		// queues are closed on shutdown (no new requests are accepted)
+		closed     bool
+		closedLock sync.RWMutex
+		workers    sync.WaitGroup
+
+		prometheus struct {
+			queueLength *prometheus.GaugeVec
+			samples     *prometheus.CounterVec
+			requests    *prometheus.CounterVec
+			retries     *prometheus.CounterVec
+			duration    *prometheus.SummaryVec
+		}
+	}
+
+	endpoint struct {
+		// url without credentials (used for logging and metrics)
+		name string
+
+		url      string
+		username string
+		password string
+
+		queue chan *writeRequest
+
+		// timestamp of the last queued sample (by series key), overlapping samples are not queued again,
+		// rolled back if a request is dropped or couldn't be sent
+		pushedTimestamps     *gocache.Cache
+		pushedTimestampsLock sync.Mutex
+	}
+
+	writeRequest struct {
+		body    []byte
+		samples int
+		series  []writeRequestSeries
+	}
+
+	// writeRequestSeries is the oldest sample of a series in a request (used for rollback of last queued timestamp)
+	writeRequestSeries struct {
+		key             string
+		oldestTimestamp int64
+	}
+)
+
+// New creates a remote-write writer for all configured urls (nil if remote-write is not configured)
+// and starts the queue workers
+func New(conf config.Opts, userAgent string, logger *slog.Logger) (*Writer, error) {
+	if len(conf.RemoteWrite.Url) == 0 {
+		return nil, nil
+	}
+
+	if conf.RemoteWrite.MaxSamplesPerSend <= 0 {
+		return nil, fmt.Errorf(`remote-write max samples per send must be greater than 0`)
+	}
+
+	w := &Writer{
+		conf:      conf,
+		userAgent: userAgent,
+		logger:    logger,
+		client:    &http.Client{Timeout: conf.RemoteWrite.Timeout},
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+
+	for _, rawUrl := range conf.RemoteWrite.Url {
+		if rawUrl = strings.TrimSpace(rawUrl); rawUrl == "" {
+			continue
+		}
+
+		e, err := newEndpoint(rawUrl, conf)
+		if err != nil {
+			return nil, err
+		}
+		w.endpoints = append(w.endpoints, e)
+	}
+
+	w.initMetrics()
+
+	for _, e := range w.endpoints {
+		w.workers.Add(1)
+		go w.run(e)
+	}
+
+	return w, nil
+}
+
+func newEndpoint(rawUrl string, conf config.Opts) (*endpoint, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid remote-write url: %w`, err)
+	}
+
+	switch parsedUrl.Scheme {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf(`remote-write url "%v" has unsupported scheme "%v"`, parsedUrl.Redacted(), parsedUrl.Scheme)
+	}
+
+	e := &endpoint{
+		username: conf.RemoteWrite.Username,
+		password: conf.RemoteWrite.Password,
+		queue:    make(chan *writeRequest, conf.RemoteWrite.QueueSize),
+
+		pushedTimestamps: gocache.New(pushedSeriesExpiry, 10*time.Minute),
+	}
+
+	// basic auth from url
+	if parsedUrl.User != nil {
+		e.username = parsedUrl.User.Username()
+		e.password, _ = parsedUrl.User.Password()
+		parsedUrl.User = nil
+	}
+
+	e.url = parsedUrl.String()
+	e.name = e.url
+
+	return e, nil
+}
+
+func (w *Writer) initMetrics() {
+	w.prometheus.queueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_remotewrite_queue_length",
+			Help: "Azure metrics remote-write queued requests",
+		},
+		[]string{"url"},
+	)
+	prometheus.MustRegister(w.prometheus.queueLength)
+
+	w.prometheus.samples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_remotewrite_samples",
+			Help: "Azure metrics remote-write samples by result (success, failed, dropped)",
+		},
+		[]string{"url", "result"},
+	)
+	prometheus.MustRegister(w.prometheus.samples)
+
+	w.prometheus.requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_remotewrite_requests",
+			Help: "Azure metrics remote-write http requests by result (success, error)",
+		},
+		[]string{"url", "result"},
+	)
+	prometheus.MustRegister(w.prometheus.requests)
+
+	w.prometheus.retries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_remotewrite_retries",
+			Help: "Azure metrics remote-write retries of failed requests",
+		},
+		[]string{"url"},
+	)
+	prometheus.MustRegister(w.prometheus.retries)
+
+	w.prometheus.duration = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name: "azurerm_stats_remotewrite_duration",
+			Help: "Azure metrics remote-write http request duration",
+		},
+		[]string{"url"},
+	)
+	prometheus.MustRegister(w.prometheus.duration)
+}
+
+// Push queues a collected metric list for all remote-write endpoints (requests are dropped if a queue is full),
+// samples which are not newer than the last queued sample of the series are skipped
+func (w *Writer) Push(metricList *metrics.MetricList) {
+	if w == nil || metricList == nil {
+		return
+	}
+
+	w.closedLock.RLock()
+	defer w.closedLock.RUnlock()
+	if w.closed {
+		return
+	}
+
+	seriesList := buildTimeseries(metricList, time.Now())
+
+	for _, e := range w.endpoints {
+		for _, batch := range splitTimeseries(e.filterPushedSamples(seriesList), w.conf.RemoteWrite.MaxSamplesPerSend) {
+			request := newWriteRequest(batch)
+
+			select {
+			case e.queue <- request:
+				w.prometheus.queueLength.WithLabelValues(e.name).Set(float64(len(e.queue)))
+			default:
+				e.rollbackPushedSamples(request)
+				w.prometheus.samples.WithLabelValues(e.name, "dropped").Add(float64(request.samples))
+				w.logger.Warn("remote-write queue is full, dropping samples", slog.String("url", e.name), slog.Int("samples", request.samples))
+			}
+		}
+	}
+}
+
+// Shutdown stops accepting new metrics and sends the queued requests,
+// requests which are not sent until ctx is done are aborted
+func (w *Writer) Shutdown(ctx context.Context) {
+	if w == nil {
+		return
+	}
+
+	w.closedLock.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, e := range w.endpoints {
+			close(e.queue)
+		}
+	}
+	w.closedLock.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		w.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		w.logger.Warn("remote-write shutdown timeout, aborting queued requests")
+		w.cancel()
+		<-finished
+	}
+}
+
+func newWriteRequest(batch []timeseries) *writeRequest {
+	request := &writeRequest{
+		body:    snappy.Encode(nil, encodeWriteRequest(batch)),
+		samples: countSamples(batch),
+	}
+
+	for _, series := range batch {
+		request.series = append(request.series, writeRequestSeries{key: series.key, oldestTimestamp: series.samples[0].timestamp})
+	}
+
+	return request
+}
+
+// filterPushedSamples returns the samples which are newer than the last queued sample of their series:
+// probes with datapoint=timestamp return the whole timespan on every run, Prometheus would reject
+// the overlapping (out-of-order) samples and the whole request with them
+func (e *endpoint) filterPushedSamples(list []timeseries) []timeseries {
+	e.pushedTimestampsLock.Lock()
+	defer e.pushedTimestampsLock.Unlock()
+
+	ret := make([]timeseries, 0, len(list))
+	for _, series := range list {
+		if val, exists := e.pushedTimestamps.Get(series.key); exists {
+			if lastTimestamp, ok := val.(int64); ok {
+				newerIndex := sort.Search(len(series.samples), func(i int) bool {
+					return series.samples[i].timestamp > lastTimestamp
+				})
+				series.samples = series.samples[newerIndex:]
+			}
+		}
+
+		if len(series.samples) == 0 {
+			continue
+		}
+
+		e.pushedTimestamps.SetDefault(series.key, series.samples[len(series.samples)-1].timestamp)
+		ret = append(ret, series)
+	}
+
+	return ret
+}
+
+// rollbackPushedSamples resets the last queued timestamps of the series of a request which was not sent,
+// so the samples are pushed again by the next collection (if they are still part of the collected timespan)
+func (e *endpoint) rollbackPushedSamples(request *writeRequest) {
+	e.pushedTimestampsLock.Lock()
+	defer e.pushedTimestampsLock.Unlock()
+
+	for _, series := range request.series {
+		if val, exists := e.pushedTimestamps.Get(series.key); exists {
+			if lastTimestamp, ok := val.(int64); ok && lastTimestamp >= series.oldestTimestamp {
+				e.pushedTimestamps.SetDefault(series.key, series.oldestTimestamp-1)
+			}
+		}
+	}
+}
+
+// run sends the queued requests of an endpoint (until the queue is closed)
+func (w *Writer) run(e *endpoint) {
+	defer w.workers.Done()
+
+	for request := range e.queue {
+		w.prometheus.queueLength.WithLabelValues(e.name).Set(float64(len(e.queue)))
+
+		retryable, err := w.sendWithRetry(e, request)
+		if err == nil {
+			w.prometheus.samples.WithLabelValues(e.name, "success").Add(float64(request.samples))
+			continue
+		}
+
+		w.prometheus.samples.WithLabelValues(e.name, "failed").Add(float64(request.samples))
+		w.logger.Error("remote-write failed", slog.String("url", e.name), slog.Any("error", err.Error()))
+
+		// samples rejected by the endpoint (eg. out-of-order) won't succeed if they are sent again
+		if retryable {
+			e.rollbackPushedSamples(request)
+		}
+	}
+}
+
+// sendWithRetry sends a request and retries retryable errors (network errors, 5xx and 429) with exponential backoff,
+// retryable is true if the last error was retryable (retries exhausted or aborted on shutdown)
+func (w *Writer) sendWithRetry(e *endpoint, request *writeRequest) (retryable bool, err error) {
+	backoff := w.conf.RemoteWrite.MinBackoff
+	for retry := 0; ; retry++ {
+		retryable, err = w.send(e, request)
+		if err == nil || !retryable || retry >= w.conf.RemoteWrite.MaxRetries {
+			return retryable, err
+		}
+
+		w.prometheus.retries.WithLabelValues(e.name).Inc()
+		w.logger.Debug("retrying remote-write request", slog.String("url", e.name), slog.Duration("backoff", backoff), slog.Any("error", err.Error()))
+
+		select {
+		case <-w.ctx.Done():
+			return true, err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, w.conf.RemoteWrite.MaxBackoff)
+	}
+}
+
+func (w *Writer) send(e *endpoint, request *writeRequest) (retryable bool, err error) {
+	startTime := time.Now()
+	defer func() {
+		w.prometheus.duration.WithLabelValues(e.name).Observe(time.Since(startTime).Seconds())
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		w.prometheus.requests.WithLabelValues(e.name, result).Inc()
+	}()
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, e.url, bytes.NewReader(request.body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", w.userAgent)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", RemoteWriteVersion)
+
+	if w.conf.RemoteWrite.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.conf.RemoteWrite.BearerToken)
+	} else if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	err = fmt.Errorf("server returned HTTP status %v: %v", resp.Status, strings.TrimSpace(string(body)))
+
+	// retry server errors and rate limiting, other client errors (eg. invalid samples) won't succeed
+	retryable = resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, err
+}
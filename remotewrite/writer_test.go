@@ -0,0 +1,651 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	// testReceiver is a local remote-write receiver which decodes requests and answers with the queued status codes
+	// (200 if no status code is queued)
+	testReceiver struct {
+		server *httptest.Server
+
+		lock     sync.Mutex
+		statuses []int
+		requests chan testRequest
+
+		// blocks responses until closed (if set)
+		block chan struct{}
+	}
+
+	testRequest struct {
+		header http.Header
+		series []testSeries
+		err    error
+	}
+
+	testSeries struct {
+		labels  []string
+		samples []sample
+	}
+)
+
+// writeRequestDescriptor describes the Prometheus remote-write 1.0 WriteRequest (prompb),
+// used to decode requests independent of the hand-written encoding
+var writeRequestDescriptor = func() protoreflect.MessageDescriptor {
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, fieldType descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		ret := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  label.Enum(),
+			Type:   fieldType.Enum(),
+		}
+		if typeName != "" {
+			ret.TypeName = proto.String(typeName)
+		}
+		return ret
+	}
+
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("remote.proto"),
+		Package: proto.String("prometheus"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("WriteRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("timeseries", 1, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".prometheus.TimeSeries")},
+			},
+			{
+				Name: proto.String("TimeSeries"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("labels", 1, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".prometheus.Label"),
+					field("samples", 2, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".prometheus.Sample"),
+				},
+			},
+			{
+				Name: proto.String("Label"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("value", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+			{
+				Name: proto.String("Sample"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("value", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					field("timestamp", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				},
+			},
+		},
+	}
+
+	fileDescriptor, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		panic(err)
+	}
+	return fileDescriptor.Messages().ByName("WriteRequest")
+}()
+
+func newTestReceiver(t *testing.T) *testReceiver {
+	t.Helper()
+
+	receiver := &testReceiver{
+		requests: make(chan testRequest, 100),
+	}
+
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := testRequest{header: r.Header.Clone()}
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			request.series, err = decodeWriteRequest(body)
+		}
+		request.err = err
+		receiver.requests <- request
+
+		if receiver.block != nil {
+			<-receiver.block
+		}
+
+		receiver.lock.Lock()
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status = receiver.statuses[0]
+			receiver.statuses = receiver.statuses[1:]
+		}
+		receiver.lock.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.server.Close)
+
+	return receiver
+}
+
+// respond queues the status codes of the next responses
+func (r *testReceiver) respond(statuses ...int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.statuses = append(r.statuses, statuses...)
+}
+
+// receive waits for the next request
+func (r *testReceiver) receive(t *testing.T) testRequest {
+	t.Helper()
+
+	select {
+	case request := <-r.requests:
+		if request.err != nil {
+			t.Fatalf("unable to decode remote-write request: %v", request.err)
+		}
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for remote-write request")
+	}
+	return testRequest{}
+}
+
+// expectNoRequest fails if a request is received within a short time
+func (r *testReceiver) expectNoRequest(t *testing.T) {
+	t.Helper()
+
+	select {
+	case request := <-r.requests:
+		t.Fatalf("expected no remote-write request, got %+v", request.series)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func decodeWriteRequest(body []byte) ([]testSeries, error) {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	message := dynamicpb.NewMessage(writeRequestDescriptor)
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+
+	timeseriesDescriptor := writeRequestDescriptor.Fields().ByName("timeseries").Message()
+	labelDescriptor := timeseriesDescriptor.Fields().ByName("labels").Message()
+	sampleDescriptor := timeseriesDescriptor.Fields().ByName("samples").Message()
+
+	var ret []testSeries
+	timeseriesList := message.Get(writeRequestDescriptor.Fields().ByName("timeseries")).List()
+	for i := 0; i < timeseriesList.Len(); i++ {
+		timeseries := timeseriesList.Get(i).Message()
+		series := testSeries{}
+
+		labelList := timeseries.Get(timeseriesDescriptor.Fields().ByName("labels")).List()
+		for j := 0; j < labelList.Len(); j++ {
+			l := labelList.Get(j).Message()
+			series.labels = append(series.labels, l.Get(labelDescriptor.Fields().ByName("name")).String()+"="+l.Get(labelDescriptor.Fields().ByName("value")).String())
+		}
+
+		sampleList := timeseries.Get(timeseriesDescriptor.Fields().ByName("samples")).List()
+		for j := 0; j < sampleList.Len(); j++ {
+			s := sampleList.Get(j).Message()
+			series.samples = append(series.samples, sample{
+				value:     s.Get(sampleDescriptor.Fields().ByName("value")).Float(),
+				timestamp: s.Get(sampleDescriptor.Fields().ByName("timestamp")).Int(),
+			})
+		}
+
+		ret = append(ret, series)
+	}
+
+	return ret, nil
+}
+
+// newTestWriter creates a writer with its own metrics registry
+func newTestWriter(t *testing.T, conf config.Opts) *Writer {
+	t.Helper()
+
+	defaultRegisterer := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer = defaultRegisterer
+	})
+
+	if conf.RemoteWrite.Timeout == 0 {
+		conf.RemoteWrite.Timeout = 5 * time.Second
+	}
+	if conf.RemoteWrite.QueueSize == 0 {
+		conf.RemoteWrite.QueueSize = 10
+	}
+	if conf.RemoteWrite.MaxSamplesPerSend == 0 {
+		conf.RemoteWrite.MaxSamplesPerSend = 100
+	}
+	if conf.RemoteWrite.MinBackoff == 0 {
+		conf.RemoteWrite.MinBackoff = 1 * time.Millisecond
+		conf.RemoteWrite.MaxBackoff = 5 * time.Millisecond
+	}
+
+	w, err := New(conf, "azure-metrics-exporter/test", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// abort pending requests
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w.Shutdown(ctx)
+	})
+
+	return w
+}
+
+func newTestConf(urls ...string) config.Opts {
+	conf := config.Opts{}
+	conf.RemoteWrite.Url = urls
+	conf.RemoteWrite.MaxRetries = 3
+	return conf
+}
+
+// expectSamples waits until the samples counter of result reaches expected
+func expectSamples(t *testing.T, w *Writer, url, result string, expected float64) {
+	t.Helper()
+
+	counter := w.prometheus.samples.WithLabelValues(url, result)
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(counter) < expected && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if value := testutil.ToFloat64(counter); value != expected {
+		t.Errorf(`expected %v samples with result "%v", got %v`, expected, result, value)
+	}
+}
+
+func timestampPtr(timestamp time.Time) *time.Time {
+	return &timestamp
+}
+
+func TestWriterPush(t *testing.T) {
+	receiver := newTestReceiver(t)
+	w := newTestWriter(t, newTestConf(receiver.server.URL))
+
+	t1 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(1 * time.Minute)
+
+	metricList := metrics.NewMetricList()
+	metricList.Add(
+		"azurerm_resource_metric",
+		// same series with multiple timestamps (datapoint=timestamp), not sorted by time
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a", "metric": "cpu", "dimension": ""}, Value: 2, Timestamp: timestampPtr(t2)},
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a", "metric": "cpu", "dimension": ""}, Value: 1, Timestamp: timestampPtr(t1)},
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "b", "metric": "cpu", "dimension": ""}, Value: 3, Timestamp: timestampPtr(t1)},
+	)
+	w.Push(metricList)
+
+	request := receiver.receive(t)
+
+	expectedHeaders := map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"User-Agent":                        "azure-metrics-exporter/test",
+		"X-Prometheus-Remote-Write-Version": RemoteWriteVersion,
+	}
+	for headerName, expected := range expectedHeaders {
+		if value := request.header.Get(headerName); value != expected {
+			t.Errorf(`expected header %v "%v", got "%v"`, headerName, expected, value)
+		}
+	}
+
+	if value := request.header.Get("Authorization"); value != "" {
+		t.Errorf(`expected no authorization header, got "%v"`, value)
+	}
+
+	expectedSeries := []testSeries{
+		{
+			labels:  []string{"__name__=azurerm_resource_metric", "metric=cpu", "resourceID=a"},
+			samples: []sample{{value: 1, timestamp: t1.UnixMilli()}, {value: 2, timestamp: t2.UnixMilli()}},
+		},
+		{
+			labels:  []string{"__name__=azurerm_resource_metric", "metric=cpu", "resourceID=b"},
+			samples: []sample{{value: 3, timestamp: t1.UnixMilli()}},
+		},
+	}
+	if !reflect.DeepEqual(request.series, expectedSeries) {
+		t.Errorf("expected series %+v, got %+v", expectedSeries, request.series)
+	}
+
+	expectSamples(t, w, receiver.server.URL, "success", 3)
+}
+
+func TestWriterPushCollectTime(t *testing.T) {
+	receiver := newTestReceiver(t)
+	w := newTestWriter(t, newTestConf(receiver.server.URL))
+
+	metricList := metrics.NewMetricList()
+	metricList.Add("azurerm_resource_info", metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: 1})
+
+	startTime := time.Now()
+	w.Push(metricList)
+
+	request := receiver.receive(t)
+	if len(request.series) != 1 || len(request.series[0].samples) != 1 {
+		t.Fatalf("expected one series with one sample, got %+v", request.series)
+	}
+
+	// rows without Azure timestamp use the collection time
+	if timestamp := request.series[0].samples[0].timestamp; timestamp < startTime.UnixMilli() || timestamp > time.Now().UnixMilli() {
+		t.Errorf("expected collection time as timestamp, got %v", time.UnixMilli(timestamp))
+	}
+}
+
+func TestWriterBasicAuth(t *testing.T) {
+	receiver := newTestReceiver(t)
+
+	t.Run("url", func(t *testing.T) {
+		serverUrl, _ := url.Parse(receiver.server.URL)
+		serverUrl.User = url.UserPassword("user", "secret")
+
+		w := newTestWriter(t, newTestConf(serverUrl.String()))
+		w.Push(newTestMetricList(time.Now()))
+
+		request := receiver.receive(t)
+		expectBasicAuth(t, request, "user", "secret")
+
+		// credentials are removed from url (used in metrics and logs)
+		expectSamples(t, w, receiver.server.URL, "success", 1)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		conf := newTestConf(receiver.server.URL)
+		conf.RemoteWrite.Username = "user"
+		conf.RemoteWrite.Password = "password"
+
+		w := newTestWriter(t, conf)
+		w.Push(newTestMetricList(time.Now()))
+
+		expectBasicAuth(t, receiver.receive(t), "user", "password")
+	})
+}
+
+func TestWriterBearerToken(t *testing.T) {
+	receiver := newTestReceiver(t)
+
+	conf := newTestConf(receiver.server.URL)
+	conf.RemoteWrite.BearerToken = "token"
+
+	w := newTestWriter(t, conf)
+	w.Push(newTestMetricList(time.Now()))
+
+	if value := receiver.receive(t).header.Get("Authorization"); value != "Bearer token" {
+		t.Errorf(`expected authorization header "Bearer token", got "%v"`, value)
+	}
+}
+
+func expectBasicAuth(t *testing.T, request testRequest, expectedUsername, expectedPassword string) {
+	t.Helper()
+
+	r := http.Request{Header: request.header}
+	username, password, ok := r.BasicAuth()
+	if !ok || username != expectedUsername || password != expectedPassword {
+		t.Errorf(`expected basic auth "%v:%v", got "%v:%v" (found: %v)`, expectedUsername, expectedPassword, username, password, ok)
+	}
+}
+
+func newTestMetricList(timestamp time.Time) *metrics.MetricList {
+	metricList := metrics.NewMetricList()
+	metricList.Add("azurerm_resource_metric", metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: 1, Timestamp: &timestamp})
+	return metricList
+}
+
+func TestWriterRetry(t *testing.T) {
+	testCases := []struct {
+		name             string
+		statuses         []int
+		expectedRequests int
+		expectedResult   string
+	}{
+		{name: "server error", statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, expectedRequests: 3, expectedResult: "success"},
+		{name: "rate limit", statuses: []int{http.StatusTooManyRequests}, expectedRequests: 2, expectedResult: "success"},
+		{name: "client error", statuses: []int{http.StatusBadRequest}, expectedRequests: 1, expectedResult: "failed"},
+		{name: "max retries", statuses: []int{500, 500, 500, 500, 500}, expectedRequests: 4, expectedResult: "failed"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			receiver := newTestReceiver(t)
+			receiver.respond(testCase.statuses...)
+
+			w := newTestWriter(t, newTestConf(receiver.server.URL))
+			w.Push(newTestMetricList(time.Now()))
+
+			for i := 0; i < testCase.expectedRequests; i++ {
+				receiver.receive(t)
+			}
+			expectSamples(t, w, receiver.server.URL, testCase.expectedResult, 1)
+			receiver.expectNoRequest(t)
+
+			if retries := testutil.ToFloat64(w.prometheus.retries.WithLabelValues(receiver.server.URL)); retries != float64(testCase.expectedRequests-1) {
+				t.Errorf("expected %v retries, got %v", testCase.expectedRequests-1, retries)
+			}
+		})
+	}
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	receiver := newTestReceiver(t)
+	receiver.block = make(chan struct{})
+
+	conf := newTestConf(receiver.server.URL)
+	conf.RemoteWrite.QueueSize = 1
+	w := newTestWriter(t, conf)
+
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// first request is sent (blocked by receiver), second is queued, third is dropped
+	w.Push(newTestMetricList(timestamp))
+	receiver.receive(t)
+	w.Push(newTestMetricList(timestamp.Add(1 * time.Minute)))
+	w.Push(newTestMetricList(timestamp.Add(2 * time.Minute)))
+
+	expectSamples(t, w, receiver.server.URL, "dropped", 1)
+
+	close(receiver.block)
+	receiver.receive(t)
+	expectSamples(t, w, receiver.server.URL, "success", 2)
+
+	// dropped samples are pushed again by the next (overlapping) collection
+	w.Push(newTestMetricList(timestamp.Add(2 * time.Minute)))
+	if request := receiver.receive(t); len(request.series) != 1 || request.series[0].samples[0].timestamp != timestamp.Add(2*time.Minute).UnixMilli() {
+		t.Errorf("expected dropped sample to be pushed again, got %+v", request.series)
+	}
+	expectSamples(t, w, receiver.server.URL, "success", 3)
+}
+
+func TestWriterPushesFailedSamplesAgain(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		statuses      []int
+		expectedAgain bool
+	}{
+		{name: "retries exhausted", statuses: []int{500, 500, 500, 500}, expectedAgain: true},
+		{name: "rejected", statuses: []int{http.StatusBadRequest}, expectedAgain: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			receiver := newTestReceiver(t)
+			receiver.respond(testCase.statuses...)
+
+			w := newTestWriter(t, newTestConf(receiver.server.URL))
+			w.Push(newTestMetricList(timestamp))
+
+			for range testCase.statuses {
+				receiver.receive(t)
+			}
+			expectSamples(t, w, receiver.server.URL, "failed", 1)
+
+			// next collection with the same (overlapping) timespan
+			w.Push(newTestMetricList(timestamp))
+			if testCase.expectedAgain {
+				if request := receiver.receive(t); len(request.series) != 1 || request.series[0].samples[0].timestamp != timestamp.UnixMilli() {
+					t.Errorf("expected failed sample to be pushed again, got %+v", request.series)
+				}
+				expectSamples(t, w, receiver.server.URL, "success", 1)
+			} else {
+				receiver.expectNoRequest(t)
+			}
+		})
+	}
+}
+
+func TestWriterShutdown(t *testing.T) {
+	receiver := newTestReceiver(t)
+	receiver.block = make(chan struct{})
+
+	w := newTestWriter(t, newTestConf(receiver.server.URL))
+
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	w.Push(newTestMetricList(timestamp))
+	receiver.receive(t)
+	w.Push(newTestMetricList(timestamp.Add(1 * time.Minute)))
+
+	shutdownFinished := make(chan struct{})
+	go func() {
+		w.Shutdown(context.Background())
+		close(shutdownFinished)
+	}()
+
+	// queued requests are sent before shutdown finishes
+	close(receiver.block)
+	receiver.receive(t)
+
+	select {
+	case <-shutdownFinished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for shutdown")
+	}
+	expectSamples(t, w, receiver.server.URL, "success", 2)
+
+	// no new requests are accepted after shutdown
+	w.Push(newTestMetricList(timestamp.Add(2 * time.Minute)))
+	receiver.expectNoRequest(t)
+}
+
+func TestWriterShutdownTimeout(t *testing.T) {
+	receiver := newTestReceiver(t)
+	receiver.respond(http.StatusServiceUnavailable)
+
+	conf := newTestConf(receiver.server.URL)
+	conf.RemoteWrite.MinBackoff = 1 * time.Hour
+	conf.RemoteWrite.MaxBackoff = 1 * time.Hour
+	w := newTestWriter(t, conf)
+
+	w.Push(newTestMetricList(time.Now()))
+	receiver.receive(t)
+
+	// retry backoff is aborted when the shutdown deadline is reached
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	startTime := time.Now()
+	w.Shutdown(ctx)
+	if duration := time.Since(startTime); duration > 5*time.Second {
+		t.Errorf("expected shutdown to abort retry backoff, took %v", duration)
+	}
+	expectSamples(t, w, receiver.server.URL, "failed", 1)
+}
+
+func TestWriterSkipsPushedSamples(t *testing.T) {
+	receiver := newTestReceiver(t)
+	w := newTestWriter(t, newTestConf(receiver.server.URL))
+
+	t1 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(1 * time.Minute)
+	t3 := t2.Add(1 * time.Minute)
+
+	newMetricList := func(timestamps ...time.Time) *metrics.MetricList {
+		metricList := metrics.NewMetricList()
+		for i, timestamp := range timestamps {
+			metricList.Add("azurerm_resource_metric", metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: float64(i), Timestamp: timestampPtr(timestamp)})
+		}
+		return metricList
+	}
+
+	w.Push(newMetricList(t1, t2))
+	if request := receiver.receive(t); len(request.series) != 1 || len(request.series[0].samples) != 2 {
+		t.Fatalf("expected one series with two samples, got %+v", request.series)
+	}
+
+	// overlapping timespan, only t3 is new
+	w.Push(newMetricList(t1, t2, t3))
+	request := receiver.receive(t)
+	if len(request.series) != 1 || !reflect.DeepEqual(request.series[0].samples, []sample{{value: 2, timestamp: t3.UnixMilli()}}) {
+		t.Fatalf("expected only sample of t3, got %+v", request.series)
+	}
+
+	// nothing new
+	w.Push(newMetricList(t2, t3))
+	receiver.expectNoRequest(t)
+
+	expectSamples(t, w, receiver.server.URL, "success", 3)
+}
+
+func TestWriterMaxSamplesPerSend(t *testing.T) {
+	receiver := newTestReceiver(t)
+
+	conf := newTestConf(receiver.server.URL)
+	conf.RemoteWrite.MaxSamplesPerSend = 2
+	w := newTestWriter(t, conf)
+
+	t1 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(1 * time.Minute)
+	t3 := t2.Add(1 * time.Minute)
+
+	metricList := metrics.NewMetricList()
+	metricList.Add(
+		"azurerm_resource_metric",
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: 1, Timestamp: timestampPtr(t1)},
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: 2, Timestamp: timestampPtr(t2)},
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "a"}, Value: 3, Timestamp: timestampPtr(t3)},
+		metrics.MetricRow{Labels: prometheus.Labels{"resourceID": "b"}, Value: 4, Timestamp: timestampPtr(t1)},
+	)
+	w.Push(metricList)
+
+	labelsA := []string{"__name__=azurerm_resource_metric", "resourceID=a"}
+	labelsB := []string{"__name__=azurerm_resource_metric", "resourceID=b"}
+
+	expectedRequests := [][]testSeries{
+		{{labels: labelsA, samples: []sample{{value: 1, timestamp: t1.UnixMilli()}, {value: 2, timestamp: t2.UnixMilli()}}}},
+		{{labels: labelsA, samples: []sample{{value: 3, timestamp: t3.UnixMilli()}}}, {labels: labelsB, samples: []sample{{value: 4, timestamp: t1.UnixMilli()}}}},
+	}
+	for _, expected := range expectedRequests {
+		if request := receiver.receive(t); !reflect.DeepEqual(request.series, expected) {
+			t.Errorf("expected series %+v, got %+v", expected, request.series)
+		}
+	}
+
+	expectSamples(t, w, receiver.server.URL, "success", 4)
+}
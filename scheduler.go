@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	probeScheduler struct {
+		jobs map[string]*probeSchedulerJob
+
+		prometheus struct {
+			lastSuccess *prometheus.GaugeVec
+			duration    *prometheus.GaugeVec
+			errors      *prometheus.CounterVec
+		}
+	}
+
+	probeSchedulerJob struct {
+		name string
+		conf *config.ConfigJob
+
+		lock       sync.RWMutex
+		metricList *metrics.MetricList
+	}
+)
+
+var (
+	scheduler *probeScheduler
+)
+
+// initScheduler starts the background collection of scheduled jobs (stopped when ctx is cancelled)
+func initScheduler(ctx context.Context) {
+	scheduler = &probeScheduler{
+		jobs: map[string]*probeSchedulerJob{},
+	}
+
+	for jobName, job := range Config.Jobs {
+		if job.IsScheduled() {
+			scheduler.jobs[jobName] = &probeSchedulerJob{
+				name: jobName,
+				conf: job,
+			}
+		}
+	}
+
+	if len(scheduler.jobs) == 0 {
+		return
+	}
+
+	scheduler.initMetrics()
+
+	logger.Info(fmt.Sprintf("starting scheduler for %v jobs", len(scheduler.jobs)))
+	for _, job := range scheduler.jobs {
+		go scheduler.run(ctx, job)
+	}
+}
+
+func (s *probeScheduler) initMetrics() {
+	s.prometheus.lastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_scheduler_job_last_success_timestamp_seconds",
+			Help: "Azure metrics scheduler timestamp of last successful job collection",
+		},
+		[]string{"job"},
+	)
+	prometheus.MustRegister(s.prometheus.lastSuccess)
+
+	s.prometheus.duration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_scheduler_job_duration_seconds",
+			Help: "Azure metrics scheduler duration of last job collection",
+		},
+		[]string{"job"},
+	)
+	prometheus.MustRegister(s.prometheus.duration)
+
+	s.prometheus.errors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_scheduler_job_errors",
+			Help: "Azure metrics scheduler count of failed job collections",
+		},
+		[]string{"job"},
+	)
+	prometheus.MustRegister(s.prometheus.errors)
+}
+
+// GetMetricList returns the latest collected metrics of a job
+func (s *probeScheduler) GetMetricList(jobName string) (*metrics.MetricList, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	job, exists := s.jobs[jobName]
+	if !exists {
+		return nil, false
+	}
+
+	job.lock.RLock()
+	defer job.lock.RUnlock()
+
+	return job.metricList, job.metricList != nil
+}
+
+// run collects a job on every schedule tick until the context is cancelled (shutdown),
+// ticks are dropped if a collection takes longer than the schedule
+func (s *probeScheduler) run(ctx context.Context, job *probeSchedulerJob) {
+	contextLogger := logger.With(slog.String("job", job.name))
+
+	ticker := time.NewTicker(job.conf.Schedule)
+	defer ticker.Stop()
+
+	for {
+		s.runJob(ctx, job, contextLogger.Logger)
+
+		select {
+		case <-ctx.Done():
+			contextLogger.Debug("stopping job collection")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *probeScheduler) runJob(ctx context.Context, job *probeSchedulerJob, contextLogger *slog.Logger) {
+	startTime := time.Now()
+	contextLogger.Debug("starting job collection")
+
+	metricList, err := s.collect(ctx, job, contextLogger)
+	duration := time.Since(startTime)
+	s.prometheus.duration.WithLabelValues(job.name).Set(duration.Seconds())
+
+	if err != nil {
+		if ctx.Err() == nil {
+			s.prometheus.errors.WithLabelValues(job.name).Inc()
+			contextLogger.Error("job collection failed", slog.Any("error", err.Error()))
+		}
+		return
+	}
+
+	// failed targets are part of the metric list (azurerm_probe_target_success), partial results are still served
+	job.lock.Lock()
+	job.metricList = metricList
+	job.lock.Unlock()
+
+	if targetErrors := metricList.GetProbeTargetErrors(); targetErrors > 0 {
+		s.prometheus.errors.WithLabelValues(job.name).Inc()
+		contextLogger.Error("job collection failed", slog.Int("failedTargets", targetErrors), slog.Duration("duration", duration))
+		return
+	}
+
+	s.prometheus.lastSuccess.WithLabelValues(job.name).SetToCurrentTime()
+	contextLogger.Debug("finished job collection", slog.Duration("duration", duration))
+}
+
+func (s *probeScheduler) collect(ctx context.Context, job *probeSchedulerJob, contextLogger *slog.Logger) (*metrics.MetricList, error) {
+	ctx, cancel := context.WithTimeout(ctx, job.conf.Schedule)
+	defer cancel()
+
+	prober, _, err := collectProbeModule(ctx, contextLogger, job.conf.Endpoint, job.conf.Values())
+	if err != nil {
+		return nil, err
+	}
+
+	// scheduled collections are always exported via OTLP (already exported by callback with --otlp.probes)
+	if !Opts.OTLP.Probes {
+		otlpExporter.Export(prober.GetMetricList())
+	}
+
+	return prober.GetMetricList(), nil
+}
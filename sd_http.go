@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	ServiceDiscoverySourceList          = "list"
+	ServiceDiscoverySourceResourceGraph = "resourcegraph"
+)
+
+// serviceDiscoveryHttpHandler returns discovered resources as Prometheus http_sd_config json
+func serviceDiscoveryHttpHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ServiceDiscoveryHttpTimeoutDefault)
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	params := r.URL.Query()
+
+	// service discovery by resources api (resourceType/filter) or resourcegraph (resourceType and kusto filter)
+	var settings metrics.RequestMetricSettings
+	source := params.Get("source")
+	if source == "" {
+		source = ServiceDiscoverySourceList
+	}
+	switch source {
+	case ServiceDiscoverySourceList:
+		settings, err = metrics.NewRequestMetricSettingsForAzureResourceApi(r, Opts)
+	case ServiceDiscoverySourceResourceGraph:
+		settings, err = metrics.NewRequestMetricSettings(r, Opts)
+	default:
+		err = fmt.Errorf(`parameter "source" has invalid value "%v"`, source)
+	}
+	if err != nil {
+		contextLogger.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prober := metrics.NewMetricProber(ctx, contextLogger.Logger, w, &settings, Opts)
+	prober.SetUserAgent(UserAgent + gitTag)
+	prober.SetAzureClient(AzureClient)
+	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	prober.SetServiceDiscoveryExcludedCounter(prometheusServiceDiscoveryExcluded.MustCurryWith(prometheus.Labels{"handler": r.URL.Path}))
+
+	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
+		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
+	}
+
+	if err = prober.ResolveSubscriptions(); err != nil {
+		contextLogger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if source == ServiceDiscoverySourceResourceGraph {
+		err = probeMetricsResourceGraphDiscovery(ctx, prober, &settings, params)
+	} else {
+		err = probeMetricsListDiscovery(ctx, prober, &settings, params)
+	}
+	if err != nil {
+		contextLogger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(prober.HttpServiceDiscoveryTargetGroups()); err != nil {
+		contextLogger.Error(err.Error())
+	}
+
+	latency := time.Since(startTime)
+	contextLogger.With(
+		slog.String("method", r.Method),
+		slog.Int("status", http.StatusOK),
+		slog.Duration("latency", latency),
+	).Debug("request handled")
+}